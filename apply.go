@@ -0,0 +1,270 @@
+package apptest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// fieldManager is the field manager of every object apptest applies.
+	fieldManager = "apptest"
+)
+
+// applyCR applies the custom resource using server-side apply. Its type meta
+// must be set. Only the fields set in the custom resource are applied, see
+// minimalObject. Fields managed by other field managers with other values,
+// e.g. because the object was changed with kubectl, are not overwritten.
+// The conflict is returned as conflictError instead. The custom resource is
+// recorded in the inventory as ref when it is created unless it is shared.
+func (a *AppSetup) applyCR(ctx context.Context, obj runtime.Object, ref inventoryObject) error {
+	err := a.ctrlClient.Get(ctx, types.NamespacedName{Name: ref.name, Namespace: ref.namespace}, obj.DeepCopyObject())
+	created, err := isNotFound(err)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	u, err := minimalObject(obj)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	a.logger.Debugf(ctx, "applying %s", ref)
+
+	err = a.ctrlClient.Patch(ctx, u, client.Apply, client.FieldOwner(fieldManager))
+	if err != nil {
+		return microerror.Mask(applyError(err, ref))
+	}
+
+	if created && !ref.shared {
+		a.inventory.add(ref)
+	}
+
+	a.logger.Debugf(ctx, "applied %s", ref)
+
+	return nil
+}
+
+// applyConfigMap applies the config map like applyCR.
+func (a *AppSetup) applyConfigMap(ctx context.Context, configMap *corev1.ConfigMap) error {
+	ref := inventoryObject{kind: kindConfigMap, name: configMap.Name, namespace: configMap.Namespace}
+	configMaps := a.k8sClient.CoreV1().ConfigMaps(configMap.Namespace)
+
+	_, err := configMaps.Get(ctx, configMap.Name, metav1.GetOptions{})
+	created, err := isNotFound(err)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	data, err := applyPatch(configMap)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	a.logger.Debugf(ctx, "applying %s", ref)
+
+	_, err = configMaps.Patch(ctx, configMap.Name, types.ApplyPatchType, data, applyOptions())
+	if err != nil {
+		return microerror.Mask(applyError(err, ref))
+	}
+
+	if created {
+		a.inventory.add(ref)
+	}
+
+	a.logger.Debugf(ctx, "applied %s", ref)
+
+	return nil
+}
+
+// applySecret applies the secret like applyCR.
+func (a *AppSetup) applySecret(ctx context.Context, secret *corev1.Secret) error {
+	ref := inventoryObject{kind: kindSecret, name: secret.Name, namespace: secret.Namespace}
+	secrets := a.k8sClient.CoreV1().Secrets(secret.Namespace)
+
+	_, err := secrets.Get(ctx, secret.Name, metav1.GetOptions{})
+	created, err := isNotFound(err)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	data, err := applyPatch(secret)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	a.logger.Debugf(ctx, "applying %s", ref)
+
+	_, err = secrets.Patch(ctx, secret.Name, types.ApplyPatchType, data, applyOptions())
+	if err != nil {
+		return microerror.Mask(applyError(err, ref))
+	}
+
+	if created {
+		a.inventory.add(ref)
+	}
+
+	a.logger.Debugf(ctx, "applied %s", ref)
+
+	return nil
+}
+
+// applyNamespace applies the namespace like applyCR.
+func (a *AppSetup) applyNamespace(ctx context.Context, namespace *corev1.Namespace) error {
+	ref := inventoryObject{kind: kindNamespace, name: namespace.Name}
+	namespaces := a.k8sClient.CoreV1().Namespaces()
+
+	_, err := namespaces.Get(ctx, namespace.Name, metav1.GetOptions{})
+	created, err := isNotFound(err)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	data, err := applyPatch(namespace)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	a.logger.Debugf(ctx, "applying %s", ref)
+
+	_, err = namespaces.Patch(ctx, namespace.Name, types.ApplyPatchType, data, applyOptions())
+	if err != nil {
+		return microerror.Mask(applyError(err, ref))
+	}
+
+	if created {
+		a.inventory.add(ref)
+	}
+
+	a.logger.Debugf(ctx, "applied %s", ref)
+
+	return nil
+}
+
+// applyOptions returns the patch options of the applies of the Kubernetes
+// client. Like the ones of applyCR they are not forced.
+func applyOptions() metav1.PatchOptions {
+	return metav1.PatchOptions{
+		FieldManager: fieldManager,
+	}
+}
+
+// applyPatch returns the minimal object of obj as apply patch.
+func applyPatch(obj runtime.Object) ([]byte, error) {
+	u, err := minimalObject(obj)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return data, nil
+}
+
+// minimalObject returns the object without its status and without the zero
+// values typed objects serialize, e.g. empty strings and structs, so apptest
+// only manages the fields it sets. Booleans are kept as false can be set on
+// purpose.
+func minimalObject(obj runtime.Object) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	delete(content, "status")
+	pruneZeroValues(content)
+
+	return &unstructured.Unstructured{Object: content}, nil
+}
+
+// valueMaps are the fields holding maps of values. Their empty values are
+// kept.
+var valueMaps = map[string]bool{
+	"annotations": true,
+	"binaryData":  true,
+	"data":        true,
+	"labels":      true,
+	"stringData":  true,
+}
+
+// pruneZeroValues removes nil values, empty strings, maps and lists from the
+// object recursively.
+func pruneZeroValues(obj map[string]interface{}) {
+	for k, v := range obj {
+		switch v := v.(type) {
+		case nil:
+			delete(obj, k)
+		case string:
+			if v == "" {
+				delete(obj, k)
+			}
+		case map[string]interface{}:
+			if !valueMaps[k] {
+				pruneZeroValues(v)
+			}
+			if len(v) == 0 {
+				delete(obj, k)
+			}
+		case []interface{}:
+			for _, item := range v {
+				m, ok := item.(map[string]interface{})
+				if ok {
+					pruneZeroValues(m)
+				}
+			}
+			if len(v) == 0 {
+				delete(obj, k)
+			}
+		}
+	}
+}
+
+// applyError returns conflictError naming the object and the conflicting
+// fields and field managers for conflicts with other field managers.
+func applyError(err error, ref inventoryObject) error {
+	if !apierrors.IsConflict(err) {
+		return err
+	}
+
+	var conflicts []string
+
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Details != nil {
+		for _, c := range status.Status().Details.Causes {
+			if c.Type == metav1.CauseTypeFieldManagerConflict {
+				conflicts = append(conflicts, fmt.Sprintf("%s %s", c.Field, c.Message))
+			}
+		}
+	}
+	if len(conflicts) == 0 {
+		conflicts = append(conflicts, err.Error())
+	}
+
+	return microerror.Maskf(conflictError, "%s has fields managed by other field managers: %s", ref, strings.Join(conflicts, ", "))
+}
+
+// isNotFound returns whether the error of a get request is a not found
+// error. Any other error is returned.
+func isNotFound(err error) (bool, error) {
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	return false, nil
+}
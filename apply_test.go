@@ -0,0 +1,138 @@
+package apptest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/apptest/internal/applyfake"
+)
+
+func newTestAppCR(version string) *v1alpha1.App {
+	return &v1alpha1.App{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.SchemeGroupVersion.String(),
+			Kind:       kindApp,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app",
+			Namespace: defaultNamespace,
+		},
+		Spec: v1alpha1.AppSpec{
+			Catalog:   "default",
+			Name:      "test-app",
+			Namespace: "test",
+			Version:   version,
+		},
+	}
+}
+
+func Test_applyCR_conflict(t *testing.T) {
+	ctx := context.Background()
+
+	a := newTestAppSetup(t, Config{})
+
+	// Another field manager applied the app CR with another version.
+	err := a.ctrlClient.Patch(ctx, newTestAppCR("0.9.0"), client.Apply, client.FieldOwner("other"))
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	err = a.applyCR(ctx, newTestAppCR("1.0.0"), inventoryObject{kind: kindApp, name: "test-app", namespace: defaultNamespace})
+	if !IsConflict(err) {
+		t.Fatalf("expected conflict error got %#v", err)
+	}
+	if !strings.Contains(err.Error(), "App 'giantswarm/test-app'") || !strings.Contains(err.Error(), `.spec.version conflict with "other"`) {
+		t.Fatalf("expected error to name the object and field manager got %#q", err.Error())
+	}
+
+	var appCR v1alpha1.App
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: defaultNamespace}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if appCR.Spec.Version != "0.9.0" {
+		t.Fatalf("expected version %#q got %#q", "0.9.0", appCR.Spec.Version)
+	}
+
+	// Fields with the same values are shared. Only the fields set by
+	// apptest are managed by it, not the zero values of the typed app CR.
+	err = a.applyCR(ctx, newTestAppCR("0.9.0"), inventoryObject{kind: kindApp, name: "test-app", namespace: defaultNamespace})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: defaultNamespace}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	var managers []string
+	for _, f := range appCR.ManagedFields {
+		managers = append(managers, f.Manager)
+		if f.Manager == fieldManager && strings.Contains(string(f.FieldsV1.Raw), "f:catalogNamespace") {
+			t.Fatalf("expected zero values not to be applied got %s", f.FieldsV1.Raw)
+		}
+	}
+	if len(managers) != 2 {
+		t.Fatalf("expected fields shared by 2 field managers got %v", managers)
+	}
+}
+
+func Test_applyConfigMap_inventory(t *testing.T) {
+	ctx := context.Background()
+
+	existing := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "existing",
+			Namespace: defaultNamespace,
+		},
+	}
+
+	a := newTestAppSetup(t, Config{})
+
+	k8sClient := k8sfake.NewSimpleClientset(existing)
+	applyfake.AddReactor(k8sClient, fieldManager)
+	a.k8sClient = k8sClient
+
+	for _, name := range []string{"existing", "created"} {
+		err := a.applyConfigMap(ctx, &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "ConfigMap",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: defaultNamespace,
+			},
+			Data: map[string]string{
+				"values": "replicas: 2",
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected nil got %#v", err)
+		}
+
+		configMap, err := a.k8sClient.CoreV1().ConfigMaps(defaultNamespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expected nil got %#v", err)
+		}
+		if configMap.Data["values"] != "replicas: 2" {
+			t.Fatalf("expected values %#q got %#q", "replicas: 2", configMap.Data["values"])
+		}
+	}
+
+	if a.inventory.contains(kindConfigMap, "existing", defaultNamespace) {
+		t.Fatalf("expected existing config map not to be recorded")
+	}
+	if !a.inventory.contains(kindConfigMap, "created", defaultNamespace) {
+		t.Fatalf("expected created config map to be recorded")
+	}
+}
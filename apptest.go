@@ -3,12 +3,13 @@ package apptest
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
 	"github.com/giantswarm/apiextensions/v3/pkg/label"
-	"github.com/giantswarm/appcatalog"
 	"github.com/giantswarm/backoff"
 	"github.com/giantswarm/microerror"
 	"github.com/giantswarm/micrologger"
@@ -18,12 +19,14 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -32,6 +35,7 @@ const (
 	notInstalledStatus = "not-installed"
 	defaultNamespace   = "giantswarm"
 	uniqueAppCRVersion = "0.0.0"
+	defaultHTTPTimeout = 30 * time.Second
 )
 
 var (
@@ -57,35 +61,209 @@ type Config struct {
 	KubeConfig     string
 	KubeConfigPath string
 
+	// CtrlClient and K8sClient can be set instead of a kubeconfig, e.g. to
+	// use clients created for envtest or fake clients in unit tests.
+	CtrlClient client.Client
+	K8sClient  kubernetes.Interface
+	// RESTConfig is returned by RESTConfig when the clients are set.
+	RESTConfig *rest.Config
+
 	Logger micrologger.Logger
 	Scheme *runtime.Scheme
+
+	// CRDWaitInterval is the maximum interval between checks whether a CRD
+	// is established. Defaults to 10 seconds.
+	CRDWaitInterval time.Duration
+	// CRDWaitTimeout is how long to wait for a CRD to be established.
+	// Defaults to 1 minute.
+	CRDWaitTimeout time.Duration
+	// WaitInterval is the interval between app CR status checks. It can be
+	// overridden per app. Defaults to 10 seconds.
+	WaitInterval time.Duration
+	// WaitTimeout is how long to wait for an app to be deployed. It can be
+	// overridden per app. Defaults to 20 minutes.
+	WaitTimeout time.Duration
+
+	// ArtifactsDir is where a diagnostics report is written when an app
+	// fails to deploy, e.g. so CI can upload it. No report is written when
+	// empty.
+	ArtifactsDir string
+	// LogTailLines is the number of container log lines included in the
+	// diagnostics report. Defaults to 100.
+	LogTailLines int64
+
+	// CatalogResolver resolves the URLs of catalogs for apps without a
+	// catalog URL. Defaults to the Giant Swarm catalogs.
+	CatalogResolver CatalogResolver
+
+	// CatalogCacheDir is where fetched catalog index.yaml files are cached
+	// across app setups. Indexes are only cached in memory when empty.
+	CatalogCacheDir string
+	// CatalogCacheTTL is how long a cached index is used before it is
+	// revalidated using its ETag. Defaults to 5 minutes.
+	CatalogCacheTTL time.Duration
+	// CatalogIndexFiles maps catalog names to vendored index.yaml files
+	// which are used instead of fetching the index.
+	CatalogIndexFiles map[string]string
+	// Offline resolves versions only from vendored or cached indexes, e.g.
+	// in air-gapped CI.
+	Offline bool
+
+	// ChartServerAddress is the address the Helm repository serving local
+	// charts listens on, e.g. 0.0.0.0:8080. It must be set to install apps
+	// with a chart path.
+	ChartServerAddress string
+	// ChartServerURL is the URL the cluster uses to reach the Helm
+	// repository serving local charts, e.g. when it runs behind a NAT.
+	// Defaults to the address it listens on. It must be set when listening
+	// on an unspecified address such as 0.0.0.0.
+	ChartServerURL string
+
+	// RunScoped isolates the app setup from others using the same cluster.
+	// A namespace named apptest-<run ID> is created and app CRs, catalog
+	// CRs, config maps and secrets as well as the target namespace of every
+	// app installed in the cluster are put in it. Apps installed in remote
+	// clusters keep their target namespace. Every object is labelled with
+	// the run ID. CleanUp deletes the namespace. Cluster-scoped appcatalog
+	// CRs are shared by the runs and left behind.
+	RunScoped bool
+	// RunID identifies the run of a run-scoped app setup. It must be a
+	// valid namespace name suffix and label value, New returns an error
+	// asserted by IsInvalidConfig otherwise. Defaults to a random ID.
+	RunID string
+
+	// TerminalStatuses are app CR release statuses and reasons which stop
+	// waiting for an app in addition to DefaultTerminalStatuses, e.g.
+	// reasons of app-operator versions apptest doesn't know about.
+	TerminalStatuses []TerminalStatus
 }
 
 // AppSetup implements the logic for managing the app setup.
 type AppSetup struct {
-	ctrlClient client.Client
-	k8sClient  kubernetes.Interface
-	logger     micrologger.Logger
-	restConfig *rest.Config
+	ctrlClient    client.Client
+	dynamicClient dynamic.Interface
+	k8sClient     kubernetes.Interface
+	logger        micrologger.Logger
+	restConfig    *rest.Config
+	scheme        *runtime.Scheme
+
+	crdWaitInterval     time.Duration
+	crdWaitTimeout      time.Duration
+	defaultWaitInterval time.Duration
+	defaultWaitTimeout  time.Duration
+
+	artifactsDir string
+	logTailLines int64
+
+	inventory *inventory
+
+	catalogResolver CatalogResolver
+
+	catalogCacheDir   string
+	catalogCacheTTL   time.Duration
+	catalogIndexFiles map[string]string
+	offline           bool
+
+	// catalogCacheMutex guards the in-memory index cache and the locks of
+	// the catalogs which serialize fetching the index of the same catalog.
+	catalogCacheMutex sync.Mutex
+	catalogIndexes    map[string]cachedIndex
+	catalogLocks      map[string]*sync.Mutex
+
+	httpClient *http.Client
+
+	chartServerAddress string
+	chartServerURL     string
+	chartServer        *chartServer
+	chartServerMutex   sync.Mutex
+
+	runID        string
+	runNamespace string
+
+	statusClassifier statusClassifier
+
+	workloadClients      map[string]*WorkloadClients
+	workloadClientsMutex sync.Mutex
 }
 
 // New creates a new configured app setup library.
 func New(config Config) (*AppSetup, error) {
 	var err error
 
-	if config.KubeConfig == "" && config.KubeConfigPath == "" {
-		return nil, microerror.Maskf(invalidConfigError, "%T.KubeConfig and %T.KubeConfigPath must not be empty at the same time", config, config)
-	}
-	if config.KubeConfig != "" && config.KubeConfigPath != "" {
-		return nil, microerror.Maskf(invalidConfigError, "%T.KubeConfig and %T.KubeConfigPath must not be set at the same time", config, config)
+	if config.CtrlClient != nil || config.K8sClient != nil {
+		if config.CtrlClient == nil || config.K8sClient == nil {
+			return nil, microerror.Maskf(invalidConfigError, "%T.CtrlClient and %T.K8sClient must be set together", config, config)
+		}
+		if config.KubeConfig != "" || config.KubeConfigPath != "" {
+			return nil, microerror.Maskf(invalidConfigError, "%T.KubeConfig and %T.KubeConfigPath must be empty when clients are set", config, config)
+		}
+	} else {
+		if config.KubeConfig == "" && config.KubeConfigPath == "" {
+			return nil, microerror.Maskf(invalidConfigError, "%T.KubeConfig and %T.KubeConfigPath must not be empty at the same time", config, config)
+		}
+		if config.KubeConfig != "" && config.KubeConfigPath != "" {
+			return nil, microerror.Maskf(invalidConfigError, "%T.KubeConfig and %T.KubeConfigPath must not be set at the same time", config, config)
+		}
 	}
 
 	if config.Logger == nil {
 		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
 	}
+	if config.RunID != "" {
+		err := validateRunID(config.RunID)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	if config.Scheme == nil {
+		config.Scheme = scheme.Scheme
+	}
+
+	if config.CRDWaitInterval == 0 {
+		config.CRDWaitInterval = defaultCRDWaitInterval
+	}
+	if config.CRDWaitTimeout == 0 {
+		config.CRDWaitTimeout = defaultCRDWaitTimeout
+	}
+	if config.WaitInterval == 0 {
+		config.WaitInterval = defaultWaitInterval
+	}
+	if config.WaitTimeout == 0 {
+		config.WaitTimeout = defaultWaitTimeout
+	}
+	if config.LogTailLines == 0 {
+		config.LogTailLines = defaultLogTailLines
+	}
+	if config.CatalogResolver == nil {
+		config.CatalogResolver = DefaultCatalogResolver()
+	}
+	if config.CatalogCacheTTL == 0 {
+		config.CatalogCacheTTL = defaultCatalogCacheTTL
+	}
+
+	statusClassifier, err := newStatusClassifier(append(DefaultTerminalStatuses(), config.TerminalStatuses...))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
 
-	var restConfig *rest.Config
-	{
+	// Extend the global client-go scheme which is used by all the tools under
+	// the hood. The scheme is required for the controller-runtime controller to
+	// be able to watch for runtime objects of a certain type.
+	appSchemeBuilder := runtime.SchemeBuilder(schemeBuilder{
+		v1alpha1.AddToScheme,
+		apiextensionsv1.AddToScheme,
+	})
+	err = appSchemeBuilder.AddToScheme(config.Scheme)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	restConfig := config.RESTConfig
+	ctrlClient := config.CtrlClient
+	k8sClient := config.K8sClient
+
+	if ctrlClient == nil {
 		if config.KubeConfig != "" {
 			bytes := []byte(config.KubeConfig)
 			restConfig, err = clientcmd.RESTConfigFromKubeConfig(bytes)
@@ -101,25 +279,6 @@ func New(config Config) (*AppSetup, error) {
 			// Shouldn't happen but returning error just in case.
 			return nil, microerror.Maskf(invalidConfigError, "%T.KubeConfig and %T.KubeConfigPath must not be empty at the same time", config, config)
 		}
-	}
-
-	var ctrlClient client.Client
-	{
-		if config.Scheme == nil {
-			config.Scheme = scheme.Scheme
-		}
-
-		// Extend the global client-go scheme which is used by all the tools under
-		// the hood. The scheme is required for the controller-runtime controller to
-		// be able to watch for runtime objects of a certain type.
-		appSchemeBuilder := runtime.SchemeBuilder(schemeBuilder{
-			v1alpha1.AddToScheme,
-			apiextensionsv1.AddToScheme,
-		})
-		err = appSchemeBuilder.AddToScheme(config.Scheme)
-		if err != nil {
-			return nil, microerror.Mask(err)
-		}
 
 		// Configure a dynamic rest mapper to the controller client so it can work
 		// with runtime objects of arbitrary types. Note that this is the default
@@ -137,49 +296,129 @@ func New(config Config) (*AppSetup, error) {
 		if err != nil {
 			return nil, microerror.Mask(err)
 		}
-	}
 
-	var k8sClient kubernetes.Interface
-	{
-		c := rest.CopyConfig(restConfig)
+		k8sClient, err = kubernetes.NewForConfig(rest.CopyConfig(restConfig))
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
 
-		k8sClient, err = kubernetes.NewForConfig(c)
+	// Waits watch objects when a REST config is available and fall back to
+	// polling with the controller-runtime client otherwise.
+	var dynamicClient dynamic.Interface
+	if restConfig != nil {
+		dynamicClient, err = dynamic.NewForConfig(rest.CopyConfig(restConfig))
 		if err != nil {
 			return nil, microerror.Mask(err)
 		}
 	}
 
 	a := &AppSetup{
-		ctrlClient: ctrlClient,
-		k8sClient:  k8sClient,
-		logger:     config.Logger,
-		restConfig: restConfig,
+		ctrlClient:    ctrlClient,
+		dynamicClient: dynamicClient,
+		k8sClient:     k8sClient,
+		logger:        config.Logger,
+		restConfig:    restConfig,
+		scheme:        config.Scheme,
+
+		crdWaitInterval:     config.CRDWaitInterval,
+		crdWaitTimeout:      config.CRDWaitTimeout,
+		defaultWaitInterval: config.WaitInterval,
+		defaultWaitTimeout:  config.WaitTimeout,
+
+		artifactsDir: config.ArtifactsDir,
+		logTailLines: config.LogTailLines,
+
+		inventory: &inventory{},
+
+		catalogResolver: config.CatalogResolver,
+
+		catalogCacheDir:   config.CatalogCacheDir,
+		catalogCacheTTL:   config.CatalogCacheTTL,
+		catalogIndexFiles: config.CatalogIndexFiles,
+		offline:           config.Offline,
+
+		catalogIndexes: map[string]cachedIndex{},
+		catalogLocks:   map[string]*sync.Mutex{},
+
+		httpClient: &http.Client{
+			Timeout: defaultHTTPTimeout,
+		},
+
+		chartServerAddress: config.ChartServerAddress,
+		chartServerURL:     config.ChartServerURL,
+
+		statusClassifier: statusClassifier,
+
+		workloadClients: map[string]*WorkloadClients{},
+	}
+
+	if config.RunScoped {
+		a.runID = config.RunID
+		if a.runID == "" {
+			a.runID = newRunID()
+		}
+		a.runNamespace = runNamespaceName(a.runID)
 	}
 
 	return a, nil
 }
 
 // InstallApps creates appcatalog and app CRs for use in automated tests
-// and ensures they are installed by our app platform.
+// and ensures they are installed by our app platform. Apps are installed
+// concurrently once the apps they depend on are installed.
 func (a *AppSetup) InstallApps(ctx context.Context, apps []App) error {
+	_, err := a.InstallAppsWithResult(ctx, apps)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// InstallAppsWithResult installs the apps like InstallApps and returns the
+// installed apps in the same order.
+func (a *AppSetup) InstallAppsWithResult(ctx context.Context, apps []App) ([]InstalledApp, error) {
 	var err error
 
+	graph, err := newDependencyGraph(apps)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	apps = a.scopeApps(apps)
+
+	err = a.ensureRunNamespace(ctx)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	apps, err = a.serveLocalCharts(ctx, apps)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
 	err = a.createCatalogs(ctx, apps)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, microerror.Mask(err)
 	}
 
 	err = a.createAppCatalogs(ctx, apps)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, microerror.Mask(err)
 	}
 
-	err = a.createApps(ctx, apps)
+	installed, err := a.installApps(ctx, apps, graph)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, microerror.Mask(err)
 	}
 
-	err = a.waitForDeployedApps(ctx, apps)
+	return installed, nil
+}
+
+// UpgradeApp installs the current app and updates it to the desired app.
+func (a *AppSetup) UpgradeApp(ctx context.Context, current, desired App) error {
+	err := a.UpgradePath(ctx, []App{current, desired})
 	if err != nil {
 		return microerror.Mask(err)
 	}
@@ -187,45 +426,67 @@ func (a *AppSetup) InstallApps(ctx context.Context, apps []App) error {
 	return nil
 }
 
-func (a *AppSetup) UpgradeApp(ctx context.Context, current, desired App) error {
+// UpgradePath installs the first app and then updates it to every following
+// app in order, waiting for the app to be deployed after each step. Steps
+// after the first only change the catalog and version of the app, the rest
+// of the app CR is kept from the first step. Steps without version and SHA
+// use the latest version in their catalog. The returned error names the
+// step which failed.
+func (a *AppSetup) UpgradePath(ctx context.Context, steps []App) error {
 	var err error
 
-	err = a.createAppCatalogs(ctx, []App{current, desired})
-	if err != nil {
-		return microerror.Mask(err)
+	if len(steps) < 2 {
+		return microerror.Maskf(invalidConfigError, "upgrade path must have at least 2 steps, got %d", len(steps))
 	}
 
-	// if current version have no specific version, use the latest instead.
-	if current.Version == "" && current.SHA == "" {
-		catalogURL, err := getCatalogURL(current)
-		if err != nil {
-			return microerror.Mask(err)
-		}
-
-		version, err := appcatalog.GetLatestVersion(ctx, catalogURL, current.Name, "")
-		if err != nil {
-			return microerror.Mask(err)
-		}
+	steps = a.scopeApps(steps)
 
-		current.Version = version
+	err = a.ensureRunNamespace(ctx)
+	if err != nil {
+		return microerror.Mask(err)
 	}
 
-	err = a.createApps(ctx, []App{current})
+	steps, err = a.serveLocalCharts(ctx, steps)
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
-	err = a.waitForDeployedApp(ctx, current)
+	err = a.createCatalogs(ctx, steps)
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
-	err = a.updateApp(ctx, desired)
+	err = a.createAppCatalogs(ctx, steps)
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
-	err = a.waitForDeployedApp(ctx, desired)
+	for i, step := range steps {
+		if i > 0 {
+			step = upgradeStep(steps[0], step)
+		}
+
+		a.logger.Debugf(ctx, "applying step %d of %d: %s", i+1, len(steps), describeStep(step))
+
+		err = a.applyStep(ctx, step)
+		if err != nil {
+			return microerror.Mask(&aggregatedError{
+				annotation: fmt.Sprintf("step %d of %d (%s) failed: %s", i+1, len(steps), describeStep(step), err),
+				errs:       []error{err},
+			})
+		}
+
+		a.logger.Debugf(ctx, "applied step %d of %d: %s", i+1, len(steps), describeStep(step))
+	}
+
+	return nil
+}
+
+// RollbackApp installs the current app, upgrades it to the desired app and
+// rolls it back to the current app, e.g. from the latest release to the
+// commit under test and back.
+func (a *AppSetup) RollbackApp(ctx context.Context, current, desired App) error {
+	err := a.UpgradePath(ctx, []App{current, desired, current})
 	if err != nil {
 		return microerror.Mask(err)
 	}
@@ -261,53 +522,72 @@ func (a *AppSetup) RESTConfig() *rest.Config {
 	return a.restConfig
 }
 
+// CleanUp deletes the objects created by the app setup in reverse creation
+// order and waits until the workloads of deleted apps are gone.
 func (a *AppSetup) CleanUp(ctx context.Context, apps []App) error {
-	for _, app := range apps {
-		err := a.ctrlClient.Delete(ctx, &v1alpha1.App{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: app.AppCRNamespace,
-				Name:      app.Name,
-			},
-			Spec: v1alpha1.AppSpec{},
-		})
-		if apierrors.IsNotFound(err) {
-			// it's ok
-		} else if err != nil {
-			return microerror.Mask(err)
+	objects := a.inventory.list()
+
+	// Apps installed by another app setup, e.g. in a previous test run, are
+	// not in the inventory.
+	for _, app := range a.scopeApps(apps) {
+		app := app
+
+		namespace := appCRNamespace(app)
+		if a.inventory.contains(kindApp, appCRName(app), namespace) {
+			continue
 		}
 
-		var appCRNamespace string
-		if app.AppCRNamespace != "" {
-			appCRNamespace = app.AppCRNamespace
-		} else {
-			appCRNamespace = defaultNamespace
+		if isRemote(app) {
+			objects = append(objects, inventoryObject{kind: kindSecret, name: kubeConfigSecretName(app), namespace: namespace})
+		}
+		if app.ValuesYAML != "" || app.Values != nil {
+			objects = append(objects, inventoryObject{kind: kindConfigMap, name: userValuesConfigMapName(app), namespace: namespace})
 		}
+		if app.SecretValuesYAML != "" || app.SecretValues != nil {
+			objects = append(objects, inventoryObject{kind: kindSecret, name: userSecretName(app), namespace: namespace})
+		}
+		objects = append(objects, inventoryObject{kind: kindApp, name: appCRName(app), namespace: namespace, app: &app})
+	}
 
-		kubeconfigSecret := &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      app.Name,
-				Namespace: appCRNamespace,
-			},
+	// App CRs are deleted first and waited for concurrently so e.g. the
+	// kubeconfig secrets and catalogs are still there while the apps are
+	// uninstalled.
+	var deletedApps []App
+	for i := len(objects) - 1; i >= 0; i-- {
+		if objects[i].kind != kindApp {
+			continue
 		}
-		err = a.ctrlClient.Delete(ctx, kubeconfigSecret)
-		if apierrors.IsNotFound(err) {
-			// it's ok
-		} else if err != nil {
+
+		err := a.deleteObject(ctx, objects[i])
+		if err != nil {
 			return microerror.Mask(err)
 		}
 
-		userValuesConfigMap := &corev1.ConfigMap{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      app.Name,
-				Namespace: appCRNamespace,
-			},
+		if objects[i].app != nil {
+			deletedApps = append(deletedApps, *objects[i].app)
 		}
-		err = a.ctrlClient.Delete(ctx, userValuesConfigMap)
-		if apierrors.IsNotFound(err) {
-			// it's ok
-		} else if err != nil {
-			return microerror.Mask(err)
+	}
+
+	err := a.waitForDeletedApps(ctx, deletedApps)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	// Other objects are deleted in reverse creation order.
+	for i := len(objects) - 1; i >= 0; i-- {
+		if objects[i].kind != kindApp {
+			err = a.deleteObject(ctx, objects[i])
+			if err != nil {
+				return microerror.Mask(err)
+			}
 		}
+
+		a.inventory.remove(objects[i])
+	}
+
+	err = a.closeChartServer(ctx)
+	if err != nil {
+		return microerror.Mask(err)
 	}
 
 	return nil
@@ -315,14 +595,23 @@ func (a *AppSetup) CleanUp(ctx context.Context, apps []App) error {
 
 func (a *AppSetup) createAppCatalogs(ctx context.Context, apps []App) error {
 	for _, app := range apps {
-		catalogURL, err := getCatalogURL(app)
+		catalogURL, err := a.getCatalogURL(app)
 		if err != nil {
 			return microerror.Mask(err)
 		}
 
-		a.logger.Debugf(ctx, "creating %#q appcatalog cr", app.CatalogName)
+		a.logger.Debugf(ctx, "ensuring %#q appcatalog cr", app.CatalogName)
+
+		// AppCatalog CRs are cluster-scoped and can't be put in the
+		// namespace of the run. Run-scoped app setups share them, they
+		// are not labelled with the run ID nor deleted by CleanUp.
+		shared := a.runID != ""
 
 		appCatalogCR := &v1alpha1.AppCatalog{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: v1alpha1.SchemeGroupVersion.String(),
+				Kind:       kindAppCatalog,
+			},
 			ObjectMeta: metav1.ObjectMeta{
 				Name: app.CatalogName,
 				Labels: map[string]string{
@@ -334,128 +623,152 @@ func (a *AppSetup) createAppCatalogs(ctx context.Context, apps []App) error {
 				Description: app.CatalogName,
 				Title:       app.CatalogName,
 				Storage: v1alpha1.AppCatalogSpecStorage{
-					Type: "helm",
+					Type: catalogType(app, catalogURL),
 					URL:  catalogURL,
 				},
 			},
 		}
-		err = a.ctrlClient.Create(ctx, appCatalogCR)
-		if apierrors.IsAlreadyExists(err) {
+		ref := inventoryObject{kind: kindAppCatalog, name: appCatalogCR.Name, shared: shared}
+
+		// AppCatalogs which were not created by the app setup are left
+		// untouched like catalogs.
+		err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: ref.name}, &v1alpha1.AppCatalog{})
+		if err == nil && !a.inventory.contains(ref.kind, ref.name, ref.namespace) {
 			a.logger.Debugf(ctx, "%#q appcatalog CR already exists", appCatalogCR.Name)
-		} else if err != nil {
+			continue
+		} else if err != nil && !apierrors.IsNotFound(err) {
 			return microerror.Mask(err)
 		}
 
-		a.logger.Debugf(ctx, "created %#q appcatalog cr", app.CatalogName)
+		err = a.applyCR(ctx, appCatalogCR, ref)
+		if err != nil {
+			return microerror.Mask(err)
+		}
 	}
 
 	return nil
 }
 
-func (a *AppSetup) createApps(ctx context.Context, apps []App) error {
-	for _, app := range apps {
-		// Get app version based on whether a commit SHA or a version was
-		// provided.
-		version, err := getVersionForApp(ctx, app)
-		if err != nil {
-			return microerror.Mask(err)
-		}
+// createApp creates the app CR with the version resolved by
+// getVersionForApp.
+func (a *AppSetup) createApp(ctx context.Context, app App, version string) error {
+	var err error
 
-		a.logger.Debugf(ctx, "creating %#q app cr from catalog %#q with version %#q", app.Name, app.CatalogName, version)
+	a.logger.Debugf(ctx, "ensuring %#q app cr from catalog %#q with version %#q", app.Name, app.CatalogName, version)
 
-		var appOperatorVersion string
+	var appOperatorVersion string
 
-		if app.AppOperatorVersion != "" {
-			appOperatorVersion = app.AppOperatorVersion
-		} else {
-			// Processed by app-operator-unique instance.
-			appOperatorVersion = uniqueAppCRVersion
-		}
+	if app.AppOperatorVersion != "" {
+		appOperatorVersion = app.AppOperatorVersion
+	} else {
+		// Processed by app-operator-unique instance.
+		appOperatorVersion = uniqueAppCRVersion
+	}
 
-		var appCRName string
+	appCRName := appCRName(app)
+	appCRNamespace := appCRNamespace(app)
 
-		if app.AppCRName != "" {
-			appCRName = app.AppCRName
-		} else {
-			appCRName = app.Name
+	var kubeConfig v1alpha1.AppSpecKubeConfig
+
+	if isRemote(app) {
+		kubeConfigName := kubeConfigSecretName(app)
+
+		remoteKubeConfig, contextName, err := remoteKubeConfig(app)
+		if err != nil {
+			return microerror.Mask(err)
 		}
 
-		var appCRNamespace string
+		err = a.createKubeConfigSecret(ctx, kubeConfigName, appCRNamespace, string(remoteKubeConfig))
+		if err != nil {
+			return microerror.Mask(err)
+		}
 
-		if app.AppCRNamespace != "" {
-			appCRNamespace = app.AppCRNamespace
-		} else {
-			appCRNamespace = defaultNamespace
+		kubeConfig = v1alpha1.AppSpecKubeConfig{
+			Context: v1alpha1.AppSpecKubeConfigContext{
+				Name: contextName,
+			},
+			InCluster: false,
+			Secret: v1alpha1.AppSpecKubeConfigSecret{
+				Name:      kubeConfigName,
+				Namespace: appCRNamespace,
+			},
+		}
+	} else {
+		kubeConfig = v1alpha1.AppSpecKubeConfig{
+			InCluster: true,
 		}
+	}
 
-		var kubeConfig v1alpha1.AppSpecKubeConfig
+	valuesYAML, err := marshalValues(app.ValuesYAML, app.Values, "Values")
+	if err != nil {
+		return microerror.Mask(err)
+	}
 
-		if app.KubeConfig != "" {
-			kubeConfigName := fmt.Sprintf("%s-kubeconfig", app.Name)
+	var userValuesConfigMap string
 
-			err := a.createKubeConfigSecret(ctx, kubeConfigName, appCRNamespace, app.KubeConfig)
-			if err != nil {
-				return microerror.Mask(err)
-			}
+	if valuesYAML != "" {
+		userValuesConfigMap = userValuesConfigMapName(app)
 
-			kubeConfig = v1alpha1.AppSpecKubeConfig{
-				Context: v1alpha1.AppSpecKubeConfigContext{
-					Name: kubeConfigName,
-				},
-				InCluster: false,
-				Secret: v1alpha1.AppSpecKubeConfigSecret{
-					Name:      kubeConfigName,
-					Namespace: appCRNamespace,
-				},
-			}
-		} else {
-			kubeConfig = v1alpha1.AppSpecKubeConfig{
-				InCluster: true,
-			}
+		err := a.ensureUserValuesConfigMap(ctx, userValuesConfigMap, appCRNamespace, valuesYAML)
+		if err != nil {
+			return microerror.Mask(err)
 		}
+	}
 
-		var userValuesConfigMap string
+	secretValuesYAML, err := marshalValues(app.SecretValuesYAML, app.SecretValues, "SecretValues")
+	if err != nil {
+		return microerror.Mask(err)
+	}
 
-		if app.ValuesYAML != "" {
-			userValuesConfigMap = fmt.Sprintf("%s-user-values", app.Name)
+	var userSecret string
 
-			err := a.ensureUserValuesConfigMap(ctx, userValuesConfigMap, appCRNamespace, app.ValuesYAML)
-			if err != nil {
-				return microerror.Mask(err)
-			}
-		}
-		appCR := &v1alpha1.App{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      appCRName,
-				Namespace: appCRNamespace,
-				Labels: map[string]string{
-					label.AppOperatorVersion: appOperatorVersion,
-					label.AppKubernetesName:  app.Name,
-				},
-			},
-			Spec: v1alpha1.AppSpec{
-				Catalog:    app.CatalogName,
-				KubeConfig: kubeConfig,
-				Name:       app.Name,
-				Namespace:  app.Namespace,
-				Version:    version,
-			},
-		}
+	if secretValuesYAML != "" {
+		userSecret = userSecretName(app)
 
-		if app.ValuesYAML != "" {
-			appCR.Spec.UserConfig.ConfigMap.Name = userValuesConfigMap
-			appCR.Spec.UserConfig.ConfigMap.Namespace = appCRNamespace
-		}
-
-		err = a.ctrlClient.Create(ctx, appCR)
-		if apierrors.IsAlreadyExists(err) {
-			a.logger.Debugf(ctx, "%#q app CR already exists", appCR.Name)
-			return nil
-		} else if err != nil {
+		err := a.ensureUserSecret(ctx, userSecret, appCRNamespace, secretValuesYAML)
+		if err != nil {
 			return microerror.Mask(err)
 		}
+	}
+
+	appCR := &v1alpha1.App{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.SchemeGroupVersion.String(),
+			Kind:       kindApp,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      appCRName,
+			Namespace: appCRNamespace,
+			Labels: a.runLabels(map[string]string{
+				label.AppOperatorVersion: appOperatorVersion,
+				label.AppKubernetesName:  app.Name,
+			}),
+		},
+		Spec: v1alpha1.AppSpec{
+			Catalog:    app.CatalogName,
+			Config:     app.Config,
+			KubeConfig: kubeConfig,
+			Name:       app.Name,
+			Namespace:  app.Namespace,
+			Version:    version,
+		},
+	}
 
-		a.logger.Debugf(ctx, "created %#q app cr", appCR.Name)
+	if a.runID != "" {
+		appCR.Spec.CatalogNamespace = a.catalogNamespace()
+	}
+	if userValuesConfigMap != "" {
+		appCR.Spec.UserConfig.ConfigMap.Name = userValuesConfigMap
+		appCR.Spec.UserConfig.ConfigMap.Namespace = appCRNamespace
+	}
+	if userSecret != "" {
+		appCR.Spec.UserConfig.Secret.Name = userSecret
+		appCR.Spec.UserConfig.Secret.Namespace = appCRNamespace
+	}
+
+	err = a.applyCR(ctx, appCR, inventoryObject{kind: kindApp, name: appCR.Name, namespace: appCR.Namespace, app: &app})
+	if err != nil {
+		return microerror.Mask(err)
 	}
 
 	return nil
@@ -463,114 +776,126 @@ func (a *AppSetup) createApps(ctx context.Context, apps []App) error {
 
 func (a *AppSetup) createCatalogs(ctx context.Context, apps []App) error {
 	for _, app := range apps {
-		catalogURL, err := getCatalogURL(app)
+		catalogURL, err := a.getCatalogURL(app)
 		if err != nil {
 			return microerror.Mask(err)
 		}
 
-		a.logger.Debugf(ctx, "creating %#q catalog cr", app.CatalogName)
+		a.logger.Debugf(ctx, "ensuring %#q catalog cr", app.CatalogName)
 
 		catalogCR := &v1alpha1.Catalog{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: v1alpha1.SchemeGroupVersion.String(),
+				Kind:       kindCatalog,
+			},
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      app.CatalogName,
-				Namespace: metav1.NamespaceDefault,
-				Labels: map[string]string{
+				Namespace: a.catalogNamespace(),
+				Labels: a.runLabels(map[string]string{
 					// Processed by app-operator-unique.
 					label.AppOperatorVersion: uniqueAppCRVersion,
-				},
+				}),
 			},
 			Spec: v1alpha1.CatalogSpec{
+				Config:      catalogConfig(app),
 				Description: app.CatalogName,
 				Title:       app.CatalogName,
 				Storage: v1alpha1.CatalogSpecStorage{
-					Type: "helm",
+					Type: catalogType(app, catalogURL),
 					URL:  catalogURL,
 				},
 			},
 		}
-		err = a.ctrlClient.Create(ctx, catalogCR)
-		if apierrors.IsAlreadyExists(err) {
+		ref := inventoryObject{kind: kindCatalog, name: catalogCR.Name, namespace: catalogCR.Namespace}
+
+		// Catalogs which were not created by the app setup, e.g. the
+		// catalogs of the app platform, are left untouched.
+		err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: ref.name, Namespace: ref.namespace}, &v1alpha1.Catalog{})
+		if err == nil && !a.inventory.contains(ref.kind, ref.name, ref.namespace) {
 			a.logger.Debugf(ctx, "%#q catalog CR already exists", catalogCR.Name)
-		} else if err != nil {
+			continue
+		} else if err != nil && !apierrors.IsNotFound(err) {
 			return microerror.Mask(err)
 		}
 
-		a.logger.Debugf(ctx, "created %#q catalog cr", app.CatalogName)
+		err = a.applyCR(ctx, catalogCR, ref)
+		if err != nil {
+			return microerror.Mask(err)
+		}
 	}
 
 	return nil
 }
 
 func (a *AppSetup) createKubeConfigSecret(ctx context.Context, name, namespace, kubeConfig string) error {
-	a.logger.Debugf(ctx, "creating secret '%s/%s'", namespace, name)
-
-	data := map[string][]byte{
-		"kubeConfig": []byte(kubeConfig),
-	}
-	desired := &corev1.Secret{
-		Data: data,
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		Data: map[string][]byte{
+			"kubeConfig": []byte(kubeConfig),
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
+			Labels:    a.runLabels(nil),
 		},
 	}
 
-	_, err := a.k8sClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
-	if apierrors.IsNotFound(err) {
-		_, err := a.k8sClient.CoreV1().Secrets(namespace).Create(ctx, desired, metav1.CreateOptions{})
-		if err != nil {
-			return microerror.Mask(err)
-		}
-
-		a.logger.Debugf(ctx, "created secret '%s/%s'", namespace, name)
-	} else {
-		_, err := a.k8sClient.CoreV1().Secrets(namespace).Update(ctx, desired, metav1.UpdateOptions{})
-		if err != nil {
-			return microerror.Mask(err)
-		}
-
-		a.logger.Debugf(ctx, "updated existing secret '%s/%s'", namespace, name)
+	err := a.applySecret(ctx, secret)
+	if err != nil {
+		return microerror.Mask(err)
 	}
 
 	return nil
 }
 
 func (a *AppSetup) ensureUserValuesConfigMap(ctx context.Context, name, namespace, valuesYAML string) error {
-	values := map[string]string{
-		"values": valuesYAML,
-	}
 	configMap := &corev1.ConfigMap{
-		Data: values,
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		Data: map[string]string{
+			"values": valuesYAML,
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
+			Labels:    a.runLabels(nil),
 		},
 	}
 
-	_, err := a.k8sClient.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
-	if apierrors.IsNotFound(err) {
-		a.logger.Debugf(ctx, "creating configmap '%s/%s'", namespace, name)
+	err := a.applyConfigMap(ctx, configMap)
+	if err != nil {
+		return microerror.Mask(err)
+	}
 
-		_, err := a.k8sClient.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, metav1.CreateOptions{})
-		if apierrors.IsAlreadyExists(err) {
-			a.logger.Debugf(ctx, "already created configmap '%s/%s'", namespace, name)
-		} else if err != nil {
-			return microerror.Mask(err)
-		}
-		a.logger.Debugf(ctx, "created configmap '%s/%s'", namespace, name)
+	return nil
+}
 
-		return nil
+func (a *AppSetup) ensureUserSecret(ctx context.Context, name, namespace, secretValuesYAML string) error {
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		Data: map[string][]byte{
+			"secrets": []byte(secretValuesYAML),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    a.runLabels(nil),
+		},
 	}
 
-	a.logger.Debugf(ctx, "updating configmap '%s/%s'", namespace, name)
-
-	_, err = a.k8sClient.CoreV1().ConfigMaps(namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	err := a.applySecret(ctx, secret)
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
-	a.logger.Debugf(ctx, "updated configmap '%s/%s'", namespace, name)
-
 	return nil
 }
 
@@ -584,30 +909,41 @@ func (a *AppSetup) ensureCRD(ctx context.Context, crd *apiextensionsv1.CustomRes
 		return microerror.Mask(err)
 	}
 
-	updatedCRD := &apiextensionsv1.CustomResourceDefinition{}
+	w := waiter{
+		description: fmt.Sprintf("CRD %#q", crd.Name),
+		name:        crd.Name,
+		objType:     &apiextensionsv1.CustomResourceDefinition{},
+		resource:    apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions"),
 
-	o := func() error {
-		err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: crd.Name}, updatedCRD)
-		if err != nil {
-			return microerror.Mask(err)
-		}
+		check: func(obj runtime.Object) error {
+			updatedCRD := obj.(*apiextensionsv1.CustomResourceDefinition)
 
-		for _, condition := range updatedCRD.Status.Conditions {
-			if condition.Type == "Established" {
-				// Fall through.
-				return nil
+			for _, condition := range updatedCRD.Status.Conditions {
+				if condition.Type == "Established" {
+					// Fall through.
+					return nil
+				}
 			}
-		}
 
-		return microerror.Maskf(executionFailedError, "CRD %#q is not established yet", crd.Name)
-	}
+			return microerror.Maskf(executionFailedError, "CRD %#q is not established yet", crd.Name)
+		},
+		get: func(ctx context.Context) (runtime.Object, error) {
+			updatedCRD := &apiextensionsv1.CustomResourceDefinition{}
 
-	n := func(err error, t time.Duration) {
-		a.logger.Errorf(ctx, err, "failed to get CRD '%s': retrying in %s", crd.Name, t)
+			err := a.ctrlClient.Get(ctx, types.NamespacedName{Name: crd.Name}, updatedCRD)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+
+			return updatedCRD, nil
+		},
+
+		backOff:  backoff.NewExponential(a.crdWaitTimeout, a.crdWaitInterval),
+		interval: a.crdWaitInterval,
+		timeout:  a.crdWaitTimeout,
 	}
 
-	b := backoff.NewExponential(1*time.Minute, 10*time.Second)
-	err = backoff.RetryNotify(o, b, n)
+	err = a.waitFor(ctx, w)
 	if err != nil {
 		return microerror.Mask(err)
 	}
@@ -615,167 +951,394 @@ func (a *AppSetup) ensureCRD(ctx context.Context, crd *apiextensionsv1.CustomRes
 	return nil
 }
 
-func (a *AppSetup) updateApp(ctx context.Context, desired App) error {
+// applyStep applies the app CR of a step of an upgrade path and waits for
+// the app to be deployed.
+func (a *AppSetup) applyStep(ctx context.Context, step App) error {
 	var err error
-	var currentApp v1alpha1.App
 
-	var appCRName string
-	if desired.AppCRName != "" {
-		appCRName = desired.AppCRName
-	} else {
-		appCRName = desired.Name
+	// If the step has no specific version, use the latest instead.
+	if step.Version == "" && step.SHA == "" {
+		version, err := a.getLatestVersion(ctx, step, "")
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		step.Version = version
 	}
 
-	var appCRNamespace string
-	if desired.AppCRNamespace != "" {
-		appCRNamespace = desired.AppCRNamespace
-	} else {
-		appCRNamespace = defaultNamespace
+	version, err := a.getVersionForApp(ctx, step)
+	if err != nil {
+		return microerror.Mask(err)
 	}
 
-	a.logger.Debugf(ctx, "finding %#q app in namespace %#q", appCRName, appCRNamespace)
+	// Wait for the version a constraint was resolved to.
+	if step.SHA == "" {
+		step.Version = version
+	}
 
-	err = a.ctrlClient.Get(
-		ctx,
-		types.NamespacedName{Name: appCRName, Namespace: appCRNamespace},
-		&currentApp)
+	err = a.createApp(ctx, step, version)
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
-	a.logger.Debugf(ctx, "found %#q app in namespace %#q", appCRName, appCRNamespace)
-
-	desiredApp := currentApp.DeepCopy()
+	err = a.waitForDeployedApp(ctx, step)
+	if err != nil {
+		return microerror.Mask(err)
+	}
 
-	var version string
-	{
-		catalogURL, err := getCatalogURL(desired)
+	if step.WaitForReady {
+		err = a.waitForReadyWorkloads(ctx, step)
 		if err != nil {
 			return microerror.Mask(err)
 		}
+	}
 
-		var appVersion string
-		if desired.SHA != "" {
-			appVersion = desired.SHA
-		} else {
-			appVersion = desired.Version
-		}
+	return nil
+}
+
+// upgradeStep returns the app of a step after the first one of an upgrade
+// path. Like an upgrade only the catalog and version of the first app are
+// changed, so its namespace, config, user config and kubeconfig are kept.
+// Waiting is configured by the step.
+func upgradeStep(first, step App) App {
+	upgraded := first
+
+	upgraded.CatalogName = step.CatalogName
+	upgraded.CatalogType = step.CatalogType
+	upgraded.CatalogURL = step.CatalogURL
+	upgraded.SHA = step.SHA
+	upgraded.Version = step.Version
+	upgraded.WaitForReady = step.WaitForReady
+	upgraded.WaitInterval = step.WaitInterval
+	upgraded.WaitTimeout = step.WaitTimeout
+
+	return upgraded
+}
+
+// installApps creates and waits for the apps concurrently. Every app is
+// installed once the apps it depends on are installed. Apps whose
+// dependencies failed are not installed. The returned error names every app
+// that failed.
+func (a *AppSetup) installApps(ctx context.Context, apps []App, graph dependencyGraph) ([]InstalledApp, error) {
+	done := make([]chan struct{}, len(apps))
+	for i := range apps {
+		done[i] = make(chan struct{})
+	}
+
+	// Apps other apps depend on are always waited for so their dependents
+	// are only installed once they are deployed.
+	dependencies := graph.dependencies()
+
+	var mutex sync.Mutex
+	errs := make([]error, len(apps))
+	installed := make([]InstalledApp, len(apps))
+
+	var wg sync.WaitGroup
+	for i := range apps {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[i])
 
-		version, err = appcatalog.GetLatestVersion(ctx, catalogURL, desired.Name, appVersion)
+			for _, dep := range graph[i] {
+				<-done[dep]
+			}
+
+			mutex.Lock()
+			for _, dep := range graph[i] {
+				if errs[dep] != nil {
+					errs[i] = microerror.Maskf(executionFailedError, "dependency %#q failed", apps[dep].Name)
+				}
+			}
+			mutex.Unlock()
+
+			if errs[i] != nil {
+				return
+			}
+
+			result, err := a.installApp(ctx, apps[i], dependencies[i])
+
+			mutex.Lock()
+			errs[i] = err
+			installed[i] = result
+			mutex.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	var failed []string
+	var failedErrs []error
+	for i, err := range errs {
 		if err != nil {
-			return microerror.Mask(err)
+			failed = append(failed, fmt.Sprintf("app %#q: %s", apps[i].Name, err))
+			failedErrs = append(failedErrs, err)
 		}
 	}
 
-	desiredApp.Spec.Version = version
-	desiredApp.Spec.Catalog = desired.CatalogName
+	if len(failed) > 0 {
+		return nil, microerror.Mask(&aggregatedError{
+			annotation: fmt.Sprintf("%d of %d apps failed to install: %s", len(failed), len(apps), strings.Join(failed, "; ")),
+			errs:       failedErrs,
+		})
+	}
 
-	a.logger.Debugf(ctx, "updating %#q app cr in namespace %#q", currentApp.Name, appCRNamespace)
-	a.logger.Debugf(ctx, "desired version: %#q", version)
-	a.logger.Debugf(ctx, "desired catalog: %#q", desired.CatalogName)
+	return installed, nil
+}
 
-	err = a.ctrlClient.Update(
-		ctx,
-		desiredApp)
+// installApp creates the app CR and waits for it to be deployed if
+// WaitForDeploy or WaitForReady is set or other apps depend on it.
+func (a *AppSetup) installApp(ctx context.Context, app App, dependency bool) (InstalledApp, error) {
+	result := InstalledApp{
+		App: app,
+		AppCR: client.ObjectKey{
+			Name:      appCRName(app),
+			Namespace: appCRNamespace(app),
+		},
+	}
+
+	catalogURL, err := a.getCatalogURL(app)
 	if err != nil {
-		return microerror.Mask(err)
+		return InstalledApp{}, microerror.Mask(err)
 	}
 
-	a.logger.Debugf(ctx, "updated %#q app cr in namespace %#q", currentApp.Name, appCRNamespace)
+	result.CatalogURL = catalogURL
 
-	return nil
-}
+	// Get app version based on whether a commit SHA or a version was
+	// provided.
+	version, err := a.getVersionForApp(ctx, app)
+	if err != nil {
+		return InstalledApp{}, microerror.Mask(err)
+	}
 
-func (a *AppSetup) waitForDeployedApps(ctx context.Context, apps []App) error {
-	for _, app := range apps {
-		if app.WaitForDeploy {
-			err := a.waitForDeployedApp(ctx, app)
-			if err != nil {
-				return microerror.Mask(err)
-			}
-		} else {
-			a.logger.Debugf(ctx, "skipping wait for deploy of %#q app cr", app.Name)
+	// Wait for the version a constraint was resolved to.
+	if app.SHA == "" {
+		app.Version = version
+	}
+
+	start := time.Now()
+
+	err = a.createApp(ctx, app, version)
+	if err != nil {
+		return InstalledApp{}, microerror.Mask(err)
+	}
+
+	result.Version = version
+
+	if app.WaitForDeploy || app.WaitForReady || dependency {
+		err = a.waitForDeployedApp(ctx, app)
+		if err != nil {
+			return InstalledApp{}, microerror.Mask(err)
+		}
+	} else {
+		a.logger.Debugf(ctx, "skipping wait for deploy of %#q app cr", app.Name)
+	}
+
+	if app.WaitForReady {
+		err = a.waitForReadyWorkloads(ctx, app)
+		if err != nil {
+			return InstalledApp{}, microerror.Mask(err)
 		}
 	}
 
-	return nil
+	result.Duration = time.Since(start)
+
+	var appCR v1alpha1.App
+	err = a.ctrlClient.Get(ctx, result.AppCR, &appCR)
+	if err != nil {
+		return InstalledApp{}, microerror.Mask(err)
+	}
+
+	result.KubeConfigSecretName = appCR.Spec.KubeConfig.Secret.Name
+	result.UserConfigMapName = appCR.Spec.UserConfig.ConfigMap.Name
+	result.UserSecretName = appCR.Spec.UserConfig.Secret.Name
+	result.Release = appCR.Status.Release
+
+	return result, nil
 }
 
 func (a *AppSetup) waitForDeployedApp(ctx context.Context, testApp App) error {
 	var err error
 
-	var appCRName string
+	appCRName := appCRName(testApp)
+	appCRNamespace := appCRNamespace(testApp)
 
-	if testApp.AppCRName != "" {
-		appCRName = testApp.AppCRName
-	} else {
-		appCRName = testApp.Name
+	a.logger.Debugf(ctx, "ensuring '%s/%s' app CR is %#q", appCRNamespace, appCRName, deployedStatus)
+
+	// last is the release status of the app CR seen last. It is reported
+	// when the wait times out. since is when the app CR got the status.
+	var mutex sync.Mutex
+	var last v1alpha1.AppStatusRelease
+	var since time.Time
+
+	w := waiter{
+		description: fmt.Sprintf("app CR '%s/%s' status %#q", appCRNamespace, appCRName, deployedStatus),
+		name:        appCRName,
+		namespace:   appCRNamespace,
+		objType:     &v1alpha1.App{},
+		resource:    v1alpha1.SchemeGroupVersion.WithResource("apps"),
+
+		check: func(obj runtime.Object) error {
+			app := obj.(*v1alpha1.App)
+
+			mutex.Lock()
+			if since.IsZero() || last.Status != app.Status.Release.Status {
+				since = time.Now()
+			}
+			last = app.Status.Release
+			duration := time.Since(since)
+			mutex.Unlock()
+
+			switch app.Status.Release.Status {
+			case notInstalledStatus, failedStatus:
+				return backoff.Permanent(newAppError(appFailedError, testApp.Name, app.Status.Release.Status, app.Status.Release.Reason, "status %#q, reason: %s", app.Status.Release.Status, app.Status.Release.Reason))
+			case deployedStatus:
+				if testApp.SHA != "" && strings.HasSuffix(app.Status.Version, testApp.SHA) {
+					return nil
+				}
+
+				if testApp.Version != "" && testApp.Version == app.Status.Version {
+					return nil
+				}
+
+				var appVersion string
+				if testApp.SHA != "" {
+					appVersion = testApp.SHA
+				} else {
+					appVersion = testApp.Version
+				}
+
+				return microerror.Maskf(executionFailedError, "waiting for version contains %#q, current version %#q", appVersion, app.Status.Version)
+			default:
+				if _, ok := a.statusClassifier.classify(app.Status.Release, duration); ok {
+					return backoff.Permanent(newAppError(terminalStatusError, testApp.Name, app.Status.Release.Status, app.Status.Release.Reason, "status %#q for %s, reason: %s", app.Status.Release.Status, duration.Round(time.Second), app.Status.Release.Reason))
+				}
+			}
+
+			return microerror.Maskf(executionFailedError, "waiting for %#q, current %#q", deployedStatus, app.Status.Release.Status)
+		},
+		get: func(ctx context.Context) (runtime.Object, error) {
+			var app v1alpha1.App
+
+			err := a.ctrlClient.Get(
+				ctx,
+				types.NamespacedName{Name: appCRName, Namespace: appCRNamespace},
+				&app)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+
+			return &app, nil
+		},
+
+		backOff:  backoff.NewConstant(a.waitTimeout(testApp), a.waitInterval(testApp)),
+		interval: a.waitInterval(testApp),
+		timeout:  a.waitTimeout(testApp),
 	}
 
-	var appCRNamespace string
+	err = a.waitFor(ctx, w)
+	if IsAppFailed(err) {
+		a.dumpDiagnostics(ctx, testApp)
+		return microerror.Mask(err)
+	} else if err != nil {
+		a.dumpDiagnostics(ctx, testApp)
 
-	if testApp.AppCRNamespace != "" {
-		appCRNamespace = testApp.AppCRNamespace
-	} else {
-		appCRNamespace = defaultNamespace
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return microerror.Mask(newAppError(waitTimeoutError, testApp.Name, last.Status, last.Reason, "app CR '%s/%s' is not %#q: %s", appCRNamespace, appCRName, deployedStatus, err))
 	}
 
-	a.logger.Debugf(ctx, "ensuring '%s/%s' app CR is %#q", appCRNamespace, appCRName, deployedStatus)
+	a.logger.Debugf(ctx, "ensured '%s/%s' app CR is deployed", appCRNamespace, testApp.Name)
 
-	var app v1alpha1.App
+	return nil
+}
 
-	o := func() error {
-		err = a.ctrlClient.Get(
-			ctx,
-			types.NamespacedName{Name: appCRName, Namespace: appCRNamespace},
-			&app)
-		if err != nil {
-			return microerror.Mask(err)
-		}
+// appCRName returns the name of the app CR which defaults to the app name.
+func appCRName(app App) string {
+	if app.AppCRName != "" {
+		return app.AppCRName
+	}
 
-		switch app.Status.Release.Status {
-		case notInstalledStatus, failedStatus:
-			return backoff.Permanent(microerror.Maskf(executionFailedError, "status %#q, reason: %s", app.Status.Release.Status, app.Status.Release.Reason))
-		case deployedStatus:
-			if testApp.SHA != "" && strings.HasSuffix(app.Status.Version, testApp.SHA) {
-				return nil
-			}
+	return app.Name
+}
 
-			if testApp.Version != "" && testApp.Version == app.Status.Version {
-				return nil
-			}
+// appCRNamespace returns the namespace of the app CR which defaults to
+// giantswarm.
+func appCRNamespace(app App) string {
+	if app.AppCRNamespace != "" {
+		return app.AppCRNamespace
+	}
 
-			var appVersion string
-			if testApp.SHA != "" {
-				appVersion = testApp.SHA
-			} else {
-				appVersion = testApp.Version
-			}
+	return defaultNamespace
+}
 
-			return microerror.Maskf(executionFailedError, "waiting for version contains %#q, current version %#q", appVersion, app.Status.Version)
-		}
+// kubeConfigSecretName returns the name of the secret holding the kubeconfig
+// of the cluster the app is installed in.
+func kubeConfigSecretName(app App) string {
+	return fmt.Sprintf("%s-kubeconfig", app.Name)
+}
+
+// userValuesConfigMapName returns the name of the config map holding the
+// user values of the app.
+func userValuesConfigMapName(app App) string {
+	return fmt.Sprintf("%s-user-values", app.Name)
+}
 
-		return microerror.Maskf(executionFailedError, "waiting for %#q, current %#q", deployedStatus, app.Status.Release.Status)
+// catalogConfig returns the catalog-level config of the app or nil when it
+// has none.
+func catalogConfig(app App) *v1alpha1.CatalogSpecConfig {
+	if app.CatalogConfig.ConfigMap == nil && app.CatalogConfig.Secret == nil {
+		return nil
 	}
 
-	n := func(err error, t time.Duration) {
-		a.logger.Errorf(ctx, err, "failed to get app CR status '%s': retrying in %s", deployedStatus, t)
+	config := app.CatalogConfig
+
+	return &config
+}
+
+// userSecretName returns the name of the secret holding the user secret
+// values of the app.
+func userSecretName(app App) string {
+	return fmt.Sprintf("%s-user-secrets", app.Name)
+}
+
+// marshalValues returns the values YAML. Values given as Go structs or maps
+// are marshalled to YAML.
+func marshalValues(valuesYAML string, values interface{}, field string) (string, error) {
+	if values == nil {
+		return valuesYAML, nil
+	}
+	if valuesYAML != "" {
+		return "", microerror.Maskf(invalidConfigError, "%s and %sYAML must not both be set", field, field)
 	}
 
-	b := backoff.NewConstant(20*time.Minute, 10*time.Second)
-	err = backoff.RetryNotify(o, b, n)
+	data, err := yaml.Marshal(values)
 	if err != nil {
-		return microerror.Mask(err)
+		return "", microerror.Maskf(invalidConfigError, "failed to marshal %s: %s", field, err)
 	}
 
-	a.logger.Debugf(ctx, "ensured '%s/%s' app CR is deployed", appCRNamespace, testApp.Name)
+	return string(data), nil
+}
 
-	return nil
+// describeStep returns the app, version and catalog of an upgrade step for
+// logs and errors.
+func describeStep(app App) string {
+	version := app.Version
+	if app.SHA != "" {
+		version = app.SHA
+	}
+	if version == "" {
+		version = "latest"
+	}
+
+	return fmt.Sprintf("app %#q version %#q from catalog %#q", app.Name, version, app.CatalogName)
 }
 
-// getCatalogURL returns the catalog URL for this app. If it is a Giant Swarm
-// catalog no URL needs to be provided.
-func getCatalogURL(app App) (string, error) {
+// getCatalogURL returns the catalog URL for this app. If the catalog is known
+// by the catalog resolver, e.g. a Giant Swarm catalog, no URL needs to be
+// provided.
+func (a *AppSetup) getCatalogURL(app App) (string, error) {
 	if app.CatalogName == "" {
 		return "", microerror.Maskf(invalidConfigError, "catalog name must not be empty for app %#v", app)
 	}
@@ -783,9 +1346,11 @@ func getCatalogURL(app App) (string, error) {
 		return app.CatalogURL, nil
 	}
 
-	catalogURL, exists := giantSwarmCatalogs[app.CatalogName]
-	if !exists {
+	catalogURL, err := a.catalogResolver.CatalogURL(app.CatalogName)
+	if IsCatalogNotFound(err) {
 		return "", microerror.Maskf(invalidConfigError, "catalog %#q not found and no URL provided", app.CatalogName)
+	} else if err != nil {
+		return "", microerror.Mask(err)
 	}
 
 	return catalogURL, nil
@@ -798,16 +1363,28 @@ func getCatalogURL(app App) (string, error) {
 //
 // If a version is provided then this is returned. This is to allow app
 // dependencies to be installed.
-func getVersionForApp(ctx context.Context, app App) (version string, err error) {
-	catalogURL, err := getCatalogURL(app)
+func (a *AppSetup) getVersionForApp(ctx context.Context, app App) (version string, err error) {
+	_, err = a.getCatalogURL(app)
 	if err != nil {
 		return "", microerror.Mask(err)
 	}
 
 	if app.SHA == "" && app.Version != "" {
-		return app.Version, nil
+		constraint, ok := parseVersionConstraint(app.Version)
+		if !ok {
+			return app.Version, nil
+		}
+
+		version, err := a.resolveVersionConstraint(ctx, app, constraint)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+
+		a.logger.Debugf(ctx, "resolved version constraint %#q of app %#q to %#q", app.Version, app.Name, version)
+
+		return version, nil
 	} else if app.SHA != "" && app.Version == "" {
-		version, err := appcatalog.GetLatestVersion(ctx, catalogURL, app.Name, app.SHA)
+		version, err := a.getLatestVersion(ctx, app, app.SHA)
 		if err != nil {
 			return "", microerror.Mask(err)
 		}
@@ -819,3 +1396,90 @@ func getVersionForApp(ctx context.Context, app App) (version string, err error)
 
 	return "", microerror.Maskf(executionFailedError, "either SHA or Version must be provided")
 }
+
+// getLatestVersion returns the latest version of the app in its catalog which
+// ends with the suffix. Versions in OCI catalogs are resolved by listing the
+// registry tags, others using the possibly cached catalog index.
+func (a *AppSetup) getLatestVersion(ctx context.Context, app App, suffix string) (string, error) {
+	catalogURL, err := a.getCatalogURL(app)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	if catalogType(app, catalogURL) == CatalogTypeOCI {
+		tags, err := a.listOCIVersions(ctx, app, catalogURL)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+
+		version, err := latestTaggedVersion(tags, suffix)
+		if err != nil {
+			return "", microerror.Mask(newAppError(versionNotFoundError, app.Name, "", "", "app %#q in OCI catalog %#q: %s", app.Name, catalogURL, err))
+		}
+
+		return version, nil
+	}
+
+	version, err := a.findInCatalogIndex(ctx, app, catalogURL, func(index catalogIndex) (string, error) {
+		return latestIndexVersion(index, app.Name, suffix)
+	})
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return version, nil
+}
+
+// MergedValues returns the values app-operator merged from the catalog,
+// cluster and user config of the app. They are read from the config map and
+// secret referenced by the chart CR in the cluster the app is installed in.
+// Secret values override config map values.
+func (a *AppSetup) MergedValues(ctx context.Context, app App) (map[string]interface{}, error) {
+	k8sClient, ctrlClient, err := a.appClients(app)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	appCRName := appCRName(app)
+
+	var chart v1alpha1.Chart
+	err = ctrlClient.Get(ctx, types.NamespacedName{Name: appCRName, Namespace: defaultNamespace}, &chart)
+	if apierrors.IsNotFound(err) {
+		return nil, microerror.Maskf(notFoundError, "chart CR '%s/%s'", defaultNamespace, appCRName)
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	values := map[string]interface{}{}
+
+	if ref := chart.Spec.Config.ConfigMap; ref.Name != "" {
+		configMap, err := k8sClient.CoreV1().ConfigMaps(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		data := map[string][]byte{}
+		for k, v := range configMap.Data {
+			data[k] = []byte(v)
+		}
+
+		err = mergeValuesData(values, data, fmt.Sprintf("config map '%s/%s'", ref.Namespace, ref.Name))
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	if ref := chart.Spec.Config.Secret; ref.Name != "" {
+		secret, err := k8sClient.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		err = mergeValuesData(values, secret.Data, fmt.Sprintf("secret '%s/%s'", ref.Namespace, ref.Name))
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,310 @@
+package apptest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/apiextensions/v3/pkg/label"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func Test_InstallApps_reconcilesDrift(t *testing.T) {
+	ctx := context.Background()
+
+	// The stale app CR was applied by apptest in a previous run with other
+	// values. Its label was added by another field manager.
+	staleApp := &v1alpha1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app",
+			Namespace: defaultNamespace,
+			Labels: map[string]string{
+				"team": "test",
+			},
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					FieldsType: "FieldsV1",
+					FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:catalog":{},"f:name":{},"f:namespace":{},"f:version":{}}}`)},
+					Manager:    fieldManager,
+					Operation:  metav1.ManagedFieldsOperationApply,
+				},
+				{
+					FieldsType: "FieldsV1",
+					FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:team":{}}}}`)},
+					Manager:    "kubectl",
+					Operation:  metav1.ManagedFieldsOperationUpdate,
+				},
+			},
+		},
+		Spec: v1alpha1.AppSpec{
+			Catalog:   "old-catalog",
+			Name:      "test-app",
+			Namespace: "old",
+			Version:   "0.9.0",
+		},
+	}
+
+	a := newTestAppSetup(t, Config{}, staleApp)
+
+	err := a.InstallApps(ctx, []App{
+		{
+			CatalogName: "default",
+			Name:        "test-app",
+			Namespace:   "test",
+			ValuesYAML:  "replicas: 2",
+			Version:     "1.0.0",
+		},
+		{
+			CatalogName: "default",
+			Name:        "other-app",
+			Namespace:   "test",
+			Version:     "1.0.0",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	var appCR v1alpha1.App
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: defaultNamespace}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	if appCR.Spec.Version != "1.0.0" {
+		t.Fatalf("expected version %#q got %#q", "1.0.0", appCR.Spec.Version)
+	}
+	if appCR.Spec.Catalog != "default" {
+		t.Fatalf("expected catalog %#q got %#q", "default", appCR.Spec.Catalog)
+	}
+	if appCR.Spec.Namespace != "test" {
+		t.Fatalf("expected namespace %#q got %#q", "test", appCR.Spec.Namespace)
+	}
+	if appCR.Spec.UserConfig.ConfigMap.Name != "test-app-user-values" {
+		t.Fatalf("expected user config map %#q got %#q", "test-app-user-values", appCR.Spec.UserConfig.ConfigMap.Name)
+	}
+	if appCR.Labels["team"] != "test" || appCR.Labels[label.AppOperatorVersion] != uniqueAppCRVersion {
+		t.Fatalf("expected existing and desired labels got %#v", appCR.Labels)
+	}
+
+	// Apps after the existing one are still created.
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "other-app", Namespace: defaultNamespace}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	// Fields changed by another field manager are not overwritten.
+	drifted := newTestAppCR("0.8.0")
+	err = a.ctrlClient.Patch(ctx, drifted, client.Apply, client.FieldOwner("kubectl"), client.ForceOwnership)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	err = a.InstallApps(ctx, []App{
+		{
+			CatalogName: "default",
+			Name:        "test-app",
+			Namespace:   "test",
+			ValuesYAML:  "replicas: 2",
+			Version:     "1.0.0",
+		},
+	})
+	if !IsConflict(err) {
+		t.Fatalf("expected conflict error got %#v", err)
+	}
+	if !strings.Contains(err.Error(), "kubectl") {
+		t.Fatalf("expected error to name field manager %#q got %#q", "kubectl", err.Error())
+	}
+
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: defaultNamespace}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if appCR.Spec.Version != "0.8.0" {
+		t.Fatalf("expected version %#q got %#q", "0.8.0", appCR.Spec.Version)
+	}
+}
+
+// deployingClient sets the status of applied app CRs after a delay like
+// app-operator and records when they were created and deployed.
+type deployingClient struct {
+	client.Client
+
+	delay  time.Duration
+	failed map[string]bool
+
+	mutex    sync.Mutex
+	created  map[string]time.Time
+	deployed map[string]time.Time
+}
+
+func (c *deployingClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	if err != nil {
+		return err
+	}
+
+	// App CRs are applied as unstructured objects.
+	if obj.GetObjectKind().GroupVersionKind().Kind != kindApp {
+		return nil
+	}
+
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.created[key.Name] = time.Now()
+	c.mutex.Unlock()
+
+	go func() {
+		time.Sleep(c.delay)
+
+		var current v1alpha1.App
+		err := c.Client.Get(context.Background(), key, &current)
+		if err != nil {
+			return
+		}
+
+		current.Status.Release.Status = deployedStatus
+		current.Status.Version = current.Spec.Version
+		if c.failed[key.Name] {
+			current.Status.Release.Status = failedStatus
+			current.Status.Release.Reason = "chart not found"
+		}
+
+		c.mutex.Lock()
+		c.deployed[key.Name] = time.Now()
+		c.mutex.Unlock()
+
+		_ = c.Client.Status().Update(context.Background(), &current)
+	}()
+
+	return nil
+}
+
+func Test_InstallApps_dependencies(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	a := newTestAppSetup(t, Config{WaitInterval: 10 * time.Millisecond})
+
+	c := &deployingClient{
+		Client: a.ctrlClient,
+
+		delay: 200 * time.Millisecond,
+		failed: map[string]bool{
+			"broken-app": true,
+		},
+
+		created:  map[string]time.Time{},
+		deployed: map[string]time.Time{},
+	}
+	a.ctrlClient = c
+
+	app := func(name string, dependsOn ...string) App {
+		return App{
+			CatalogName: "default",
+			DependsOn:   dependsOn,
+			Name:        name,
+			Namespace:   "test",
+			Version:     "1.0.0",
+		}
+	}
+
+	// Dependencies are waited for without WaitForDeploy.
+	err := a.InstallApps(ctx, []App{
+		app("base-app"),
+		app("dependent-app", "base-app"),
+		app("independent-app"),
+		app("broken-app"),
+		app("broken-dependent-app", "broken-app"),
+	})
+
+	if !IsAppFailed(err) {
+		t.Fatalf("expected app failed error got %#v", err)
+	}
+
+	var appErr *AppError
+	if !errors.As(err, &appErr) || appErr.Name != "broken-app" {
+		t.Fatalf("expected app error for %#q got %#v", "broken-app", err)
+	}
+	for _, name := range []string{"2 of 5 apps failed", "broken-app", "broken-dependent-app"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Fatalf("expected error to contain %#q got %#q", name, err.Error())
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.deployed["base-app"].IsZero() || c.created["dependent-app"].Before(c.deployed["base-app"]) {
+		t.Fatalf("expected %#q to be created after %#q was deployed", "dependent-app", "base-app")
+	}
+	if c.created["independent-app"].After(c.deployed["base-app"]) {
+		t.Fatalf("expected %#q to be created concurrently with %#q", "independent-app", "base-app")
+	}
+	if _, ok := c.created["broken-dependent-app"]; ok {
+		t.Fatalf("expected %#q not to be created", "broken-dependent-app")
+	}
+}
+
+func Test_UpgradePath_keepsApp(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	a := newTestAppSetup(t, Config{WaitInterval: 10 * time.Millisecond})
+	a.ctrlClient = &deployingClient{
+		Client: a.ctrlClient,
+
+		created:  map[string]time.Time{},
+		deployed: map[string]time.Time{},
+	}
+
+	// Later steps only change the catalog and version.
+	err := a.UpgradePath(ctx, []App{
+		{
+			CatalogName:       "default",
+			KubeConfigContext: "admin@workload",
+			KubeConfigPath:    writeTestKubeConfig(t),
+			Name:              "test-app",
+			Namespace:         "test",
+			ValuesYAML:        "replicas: 2",
+			Version:           "1.0.0",
+		},
+		{
+			CatalogName: "default",
+			Name:        "test-app",
+			Version:     "1.1.0",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	var appCR v1alpha1.App
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: defaultNamespace}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if appCR.Spec.Version != "1.1.0" {
+		t.Fatalf("expected version %#q got %#q", "1.1.0", appCR.Spec.Version)
+	}
+	if appCR.Spec.Namespace != "test" {
+		t.Fatalf("expected namespace %#q got %#q", "test", appCR.Spec.Namespace)
+	}
+	if appCR.Spec.KubeConfig.InCluster || appCR.Spec.KubeConfig.Secret.Name != "test-app-kubeconfig" {
+		t.Fatalf("expected kubeconfig secret %#q got %#v", "test-app-kubeconfig", appCR.Spec.KubeConfig)
+	}
+	if appCR.Spec.UserConfig.ConfigMap.Name != "test-app-user-values" {
+		t.Fatalf("expected user config map %#q got %#q", "test-app-user-values", appCR.Spec.UserConfig.ConfigMap.Name)
+	}
+}
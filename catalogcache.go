@@ -0,0 +1,346 @@
+package apptest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/appcatalog"
+	"github.com/giantswarm/microerror"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultCatalogCacheTTL = 5 * time.Minute
+)
+
+// catalogIndex is the parsed index.yaml of a Helm repository.
+type catalogIndex struct {
+	Entries map[string][]appcatalog.Entry `json:"entries"`
+}
+
+// cachedIndex is an index.yaml cached in memory.
+type cachedIndex struct {
+	data     []byte
+	metadata cachedIndexMetadata
+}
+
+// cachedIndexMetadata is stored next to a cached index.yaml.
+type cachedIndexMetadata struct {
+	ETag      string    `json:"etag,omitempty"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	URL       string    `json:"url"`
+}
+
+// getCatalogIndex returns the parsed index.yaml of the app's catalog, see
+// readCatalogIndex.
+func (a *AppSetup) getCatalogIndex(ctx context.Context, app App, catalogURL string, refresh bool) (catalogIndex, bool, error) {
+	data, cached, err := a.readCatalogIndex(ctx, app, catalogURL, refresh)
+	if err != nil {
+		return catalogIndex{}, false, microerror.Mask(err)
+	}
+
+	index, err := parseCatalogIndex(data)
+	if err != nil {
+		return catalogIndex{}, false, microerror.Mask(err)
+	}
+
+	return index, cached, nil
+}
+
+// readCatalogIndex returns the index.yaml of the app's catalog. Vendored
+// index files configured for the catalog are always used. Otherwise a cached
+// index younger than the TTL is used unless refresh is set, an older one is
+// revalidated using its ETag. In offline mode only vendored and cached
+// indexes are used. Indexes of the same catalog are fetched one at a time,
+// different catalogs concurrently. The returned bool is whether the index
+// was cached within the TTL and can be refreshed.
+func (a *AppSetup) readCatalogIndex(ctx context.Context, app App, catalogURL string, refresh bool) ([]byte, bool, error) {
+	if path, ok := a.catalogIndexFiles[app.CatalogName]; ok {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, false, microerror.Mask(err)
+		}
+
+		return data, false, nil
+	}
+
+	lock := a.catalogLock(catalogURL)
+	lock.Lock()
+	defer lock.Unlock()
+
+	cached, metadata, err := a.readCachedIndex(catalogURL)
+	if err != nil {
+		return nil, false, microerror.Mask(err)
+	}
+
+	if a.offline {
+		if cached == nil {
+			return nil, false, microerror.Mask(newAppError(catalogUnreachableError, app.Name, "", "", "index of catalog %#q is not cached and offline mode is enabled", app.CatalogName))
+		}
+
+		return cached, false, nil
+	}
+
+	if cached != nil && !refresh && time.Since(metadata.FetchedAt) < a.catalogCacheTTL {
+		return cached, true, nil
+	}
+
+	data, etag, err := a.fetchIndex(ctx, catalogURL, metadata.ETag)
+	if err != nil && cached != nil {
+		a.logger.Errorf(ctx, err, "failed to fetch index of catalog %#q, using cached index from %s", app.CatalogName, metadata.FetchedAt.Format(time.RFC3339))
+		return cached, false, nil
+	} else if err != nil {
+		return nil, false, microerror.Mask(newAppError(catalogUnreachableError, app.Name, "", "", "failed to fetch index of catalog %#q: %s", app.CatalogName, err))
+	}
+
+	// The cached index is still up to date.
+	if data == nil {
+		data = cached
+	}
+
+	err = a.writeCachedIndex(catalogURL, data, cachedIndexMetadata{ETag: etag, FetchedAt: time.Now().UTC(), URL: catalogURL})
+	if err != nil {
+		return nil, false, microerror.Mask(err)
+	}
+
+	return data, false, nil
+}
+
+// findInCatalogIndex calls find with the index.yaml of the app's catalog.
+// When find returns versionNotFoundError for a cached index younger than the
+// TTL, the index is refetched once as the version may have been published
+// after the index was cached.
+func (a *AppSetup) findInCatalogIndex(ctx context.Context, app App, catalogURL string, find func(catalogIndex) (string, error)) (string, error) {
+	index, cached, err := a.getCatalogIndex(ctx, app, catalogURL, false)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	version, err := find(index)
+	if IsVersionNotFound(err) && cached {
+		a.logger.Debugf(ctx, "refetching cached index of catalog %#q: %s", app.CatalogName, err)
+
+		index, _, err = a.getCatalogIndex(ctx, app, catalogURL, true)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+
+		version, err = find(index)
+	}
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return version, nil
+}
+
+// catalogLock returns the lock of the catalog.
+func (a *AppSetup) catalogLock(catalogURL string) *sync.Mutex {
+	a.catalogCacheMutex.Lock()
+	defer a.catalogCacheMutex.Unlock()
+
+	key := strings.TrimSuffix(catalogURL, "/")
+
+	lock, ok := a.catalogLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		a.catalogLocks[key] = lock
+	}
+
+	return lock
+}
+
+// fetchIndex gets the index.yaml of the catalog. When the ETag matches no
+// data is returned.
+func (a *AppSetup) fetchIndex(ctx context.Context, catalogURL, etag string) ([]byte, string, error) {
+	indexURL := fmt.Sprintf("%s/index.yaml", strings.TrimSuffix(catalogURL, "/"))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, indexURL, nil)
+	if err != nil {
+		return nil, "", microerror.Mask(err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, "", microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, etag, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", microerror.Maskf(executionFailedError, "getting %#q returned status %d", indexURL, resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", microerror.Mask(err)
+	}
+
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// readCachedIndex returns the cached index of the catalog or nil when it is
+// not cached. The index cached in memory is preferred over the one in the
+// cache directory.
+func (a *AppSetup) readCachedIndex(catalogURL string) ([]byte, cachedIndexMetadata, error) {
+	a.catalogCacheMutex.Lock()
+	cached, ok := a.catalogIndexes[strings.TrimSuffix(catalogURL, "/")]
+	a.catalogCacheMutex.Unlock()
+
+	if ok {
+		return cached.data, cached.metadata, nil
+	}
+
+	if a.catalogCacheDir == "" {
+		return nil, cachedIndexMetadata{}, nil
+	}
+
+	indexPath, metadataPath := cachePaths(a.catalogCacheDir, catalogURL)
+
+	data, err := ioutil.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		return nil, cachedIndexMetadata{}, nil
+	} else if err != nil {
+		return nil, cachedIndexMetadata{}, microerror.Mask(err)
+	}
+
+	var metadata cachedIndexMetadata
+	{
+		b, err := ioutil.ReadFile(metadataPath)
+		if os.IsNotExist(err) {
+			// Treat the index as expired.
+			return data, metadata, nil
+		} else if err != nil {
+			return nil, cachedIndexMetadata{}, microerror.Mask(err)
+		}
+
+		err = json.Unmarshal(b, &metadata)
+		if err != nil {
+			return data, cachedIndexMetadata{}, nil
+		}
+	}
+
+	return data, metadata, nil
+}
+
+// writeCachedIndex caches the index of the catalog in memory and in the
+// cache directory if configured.
+func (a *AppSetup) writeCachedIndex(catalogURL string, data []byte, metadata cachedIndexMetadata) error {
+	a.catalogCacheMutex.Lock()
+	a.catalogIndexes[strings.TrimSuffix(catalogURL, "/")] = cachedIndex{data: data, metadata: metadata}
+	a.catalogCacheMutex.Unlock()
+
+	if a.catalogCacheDir == "" {
+		return nil
+	}
+
+	err := os.MkdirAll(a.catalogCacheDir, 0755)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	indexPath, metadataPath := cachePaths(a.catalogCacheDir, catalogURL)
+
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = writeFileAtomic(indexPath, data)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	err = writeFileAtomic(metadataPath, b)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// latestIndexVersion returns the version of the most recently created entry
+// of the app which ends with the suffix like appcatalog.GetLatestVersion.
+func latestIndexVersion(index catalogIndex, app, suffix string) (string, error) {
+	entries, ok := index.Entries[app]
+	if !ok {
+		return "", microerror.Mask(newAppError(versionNotFoundError, app, "", "", "no app %#q in index.yaml", app))
+	}
+
+	var latest *appcatalog.Entry
+	for i, e := range entries {
+		if !strings.HasSuffix(e.Version, suffix) {
+			continue
+		}
+
+		if latest == nil || e.Created.After(latest.Created) {
+			latest = &entries[i]
+		}
+	}
+
+	if latest == nil {
+		return "", microerror.Mask(newAppError(versionNotFoundError, app, "", "", "no app %#q in index.yaml with given appVersion %#q", app, suffix))
+	}
+
+	return latest.Version, nil
+}
+
+func parseCatalogIndex(data []byte) (catalogIndex, error) {
+	var index catalogIndex
+	err := yaml.Unmarshal(data, &index)
+	if err != nil {
+		return catalogIndex{}, microerror.Mask(err)
+	}
+
+	return index, nil
+}
+
+// cachePaths returns the paths of the cached index and its metadata. Files
+// are named after the hash of the catalog URL.
+func cachePaths(dir, catalogURL string) (string, string) {
+	name := fmt.Sprintf("%x", sha256.Sum256([]byte(strings.TrimSuffix(catalogURL, "/"))))[:16]
+
+	return filepath.Join(dir, name+"-index.yaml"), filepath.Join(dir, name+"-metadata.json")
+}
+
+// writeFileAtomic writes the file via a temporary file so concurrent readers
+// never see partial data.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return microerror.Mask(err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return microerror.Mask(err)
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package apptest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testIndexYAML = `apiVersion: v1
+entries:
+  test-app:
+  - created: "2021-08-01T10:00:00Z"
+    name: test-app
+    version: 1.0.0
+  - created: "2021-08-02T10:00:00Z"
+    name: test-app
+    version: 1.1.0
+`
+)
+
+// newTestCatalog returns a catalog serving testIndexYAML with an ETag and
+// counting requests and not modified responses.
+func newTestCatalog(requests, notModified *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, testIndexYAML)
+	}))
+}
+
+func Test_getLatestVersion_cache(t *testing.T) {
+	ctx := context.Background()
+
+	var requests, notModified int32
+	server := newTestCatalog(&requests, &notModified)
+
+	cacheDir := t.TempDir()
+	app := App{CatalogName: "test", CatalogURL: server.URL, Name: "test-app"}
+
+	a := newTestAppSetup(t, Config{CatalogCacheDir: cacheDir, CatalogCacheTTL: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		version, err := a.getLatestVersion(ctx, app, "")
+		if err != nil {
+			t.Fatalf("expected nil got %#v", err)
+		}
+		if version != "1.1.0" {
+			t.Fatalf("expected version %#q got %#q", "1.1.0", version)
+		}
+	}
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("expected 1 request within TTL got %d", atomic.LoadInt32(&requests))
+	}
+
+	// The expired index is revalidated using its ETag.
+	a = newTestAppSetup(t, Config{CatalogCacheDir: cacheDir, CatalogCacheTTL: time.Nanosecond})
+
+	_, err := a.getLatestVersion(ctx, app, "")
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if atomic.LoadInt32(&requests) != 2 || atomic.LoadInt32(&notModified) != 1 {
+		t.Fatalf("expected 2 requests and 1 not modified got %d and %d", atomic.LoadInt32(&requests), atomic.LoadInt32(&notModified))
+	}
+
+	server.Close()
+
+	// Offline mode only uses the cache.
+	a = newTestAppSetup(t, Config{CatalogCacheDir: cacheDir, Offline: true})
+
+	version, err := a.getLatestVersion(ctx, app, "1.0.0")
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if version != "1.0.0" {
+		t.Fatalf("expected version %#q got %#q", "1.0.0", version)
+	}
+
+	a = newTestAppSetup(t, Config{CatalogCacheDir: t.TempDir(), Offline: true})
+
+	_, err = a.getLatestVersion(ctx, app, "")
+	if !IsCatalogUnreachable(err) {
+		t.Fatalf("expected catalog unreachable error for uncached index in offline mode got %#v", err)
+	}
+}
+
+func Test_getLatestVersion_refetch(t *testing.T) {
+	ctx := context.Background()
+
+	// Every stage publishes another version of the app.
+	entries := []string{
+		"",
+		"\n  - created: \"2021-08-03T10:00:00Z\"\n    name: test-app\n    version: 1.2.0",
+		"\n  - created: \"2021-08-04T10:00:00Z\"\n    name: test-app\n    version: 1.3.0-ad12c88111d7513114a1257994634e2ae81115a2",
+	}
+
+	var requests, stage int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		current := atomic.LoadInt32(&stage)
+		etag := fmt.Sprintf(`"v%d"`, current)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		index := testIndexYAML
+		for _, e := range entries[:current+1] {
+			index += e
+		}
+
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, index)
+	}))
+	defer server.Close()
+
+	app := App{CatalogName: "test", CatalogURL: server.URL, Name: "test-app"}
+
+	a := newTestAppSetup(t, Config{CatalogCacheTTL: time.Hour})
+
+	_, err := a.getLatestVersion(ctx, app, "")
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	// Versions missing from the cached index are refetched once.
+	atomic.StoreInt32(&stage, 1)
+
+	constrained := app
+	constrained.Version = "^1.2"
+
+	version, err := a.getVersionForApp(ctx, constrained)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if version != "1.2.0" {
+		t.Fatalf("expected version %#q got %#q", "1.2.0", version)
+	}
+
+	atomic.StoreInt32(&stage, 2)
+
+	version, err = a.getLatestVersion(ctx, app, "ad12c88111d7513114a1257994634e2ae81115a2")
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if version != "1.3.0-ad12c88111d7513114a1257994634e2ae81115a2" {
+		t.Fatalf("expected version %#q got %#q", "1.3.0-ad12c88111d7513114a1257994634e2ae81115a2", version)
+	}
+	if atomic.LoadInt32(&requests) != 3 {
+		t.Fatalf("expected 3 requests got %d", atomic.LoadInt32(&requests))
+	}
+
+	_, err = a.getLatestVersion(ctx, app, "missing")
+	if !IsVersionNotFound(err) {
+		t.Fatalf("expected version not found error got %#v", err)
+	}
+	if atomic.LoadInt32(&requests) != 4 {
+		t.Fatalf("expected 4 requests got %d", atomic.LoadInt32(&requests))
+	}
+}
+
+func Test_getLatestVersion_vendoredIndex(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "index.yaml")
+	err := ioutil.WriteFile(indexPath, []byte(testIndexYAML), 0644) // #nosec
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	a := newTestAppSetup(t, Config{
+		CatalogIndexFiles: map[string]string{
+			"default": indexPath,
+		},
+		Offline: true,
+	})
+
+	version, err := a.getLatestVersion(context.Background(), App{CatalogName: "default", Name: "test-app"}, "")
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if version != "1.1.0" {
+		t.Fatalf("expected version %#q got %#q", "1.1.0", version)
+	}
+}
+
+func Test_getLatestVersion_memoryCache(t *testing.T) {
+	ctx := context.Background()
+
+	var requests, notModified int32
+	server := newTestCatalog(&requests, &notModified)
+	defer server.Close()
+
+	app := App{CatalogName: "test", CatalogURL: server.URL, Name: "test-app"}
+
+	// Without cache directory the index is cached in memory.
+	a := newTestAppSetup(t, Config{CatalogCacheTTL: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		_, err := a.getLatestVersion(ctx, app, "")
+		if err != nil {
+			t.Fatalf("expected nil got %#v", err)
+		}
+	}
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("expected 1 request within TTL got %d", atomic.LoadInt32(&requests))
+	}
+}
+
+func Test_getLatestVersion_concurrentCatalogs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The slow catalog only responds once the other catalog was fetched,
+	// which never happens when fetching one catalog blocks the others.
+	fetched := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-fetched:
+		case <-r.Context().Done():
+			return
+		}
+
+		fmt.Fprint(w, testIndexYAML)
+	}))
+	defer slow.Close()
+
+	var requests, notModified int32
+	fast := newTestCatalog(&requests, &notModified)
+	defer fast.Close()
+
+	a := newTestAppSetup(t, Config{})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := a.getLatestVersion(ctx, App{CatalogName: "slow", CatalogURL: slow.URL, Name: "test-app"}, "")
+		errs <- err
+	}()
+
+	// Give the slow catalog a head start.
+	time.Sleep(50 * time.Millisecond)
+
+	_, err := a.getLatestVersion(ctx, App{CatalogName: "fast", CatalogURL: fast.URL, Name: "test-app"}, "")
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	close(fetched)
+
+	err = <-errs
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package apptest
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultCatalogEnvPrefix is the prefix of environment variables
+	// overriding catalog URLs, e.g. APPTEST_CATALOG_DEFAULT_TEST for the
+	// default-test catalog.
+	DefaultCatalogEnvPrefix = "APPTEST_CATALOG_"
+)
+
+// CatalogResolver resolves the URL of a catalog from its name. It is used for
+// apps without a catalog URL.
+type CatalogResolver interface {
+	// CatalogURL returns the URL of the catalog. ErrCatalogNotFound, also
+	// wrapped, is returned for unknown catalogs so other resolvers and the
+	// catalog URL of the app are tried.
+	CatalogURL(name string) (string, error)
+}
+
+// StaticCatalogResolver resolves catalog URLs from a map of catalog names to
+// URLs.
+type StaticCatalogResolver map[string]string
+
+// DefaultCatalogResolver returns a resolver for the Giant Swarm catalogs.
+func DefaultCatalogResolver() StaticCatalogResolver {
+	r := StaticCatalogResolver{}
+	for name, url := range giantSwarmCatalogs {
+		r[name] = url
+	}
+
+	return r
+}
+
+// NewFileCatalogResolver reads a YAML or JSON file mapping catalog names to
+// URLs.
+func NewFileCatalogResolver(path string) (StaticCatalogResolver, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var r StaticCatalogResolver
+	err = yaml.Unmarshal(data, &r)
+	if err != nil {
+		return nil, microerror.Maskf(invalidConfigError, "failed to parse catalogs file %#q: %s", path, err)
+	}
+
+	return r, nil
+}
+
+// CatalogURL returns the URL of the catalog.
+func (r StaticCatalogResolver) CatalogURL(name string) (string, error) {
+	url, ok := r[name]
+	if !ok {
+		return "", microerror.Maskf(ErrCatalogNotFound, "catalog %#q", name)
+	}
+
+	return url, nil
+}
+
+// ChainCatalogResolver asks each resolver in order and returns the first URL
+// found, e.g. to fall back to the default catalogs.
+type ChainCatalogResolver []CatalogResolver
+
+// CatalogURL returns the URL of the catalog from the first resolver knowing
+// it.
+func (r ChainCatalogResolver) CatalogURL(name string) (string, error) {
+	for _, resolver := range r {
+		url, err := resolver.CatalogURL(name)
+		if IsCatalogNotFound(err) {
+			continue
+		} else if err != nil {
+			return "", microerror.Mask(err)
+		}
+
+		return url, nil
+	}
+
+	return "", microerror.Maskf(ErrCatalogNotFound, "catalog %#q", name)
+}
+
+// EnvCatalogResolver overrides catalog URLs with environment variables, e.g.
+// to use a mirror. The variable name is the prefix followed by the upper case
+// catalog name with dashes replaced by underscores. Other catalogs are
+// resolved by the wrapped resolver.
+type EnvCatalogResolver struct {
+	// Prefix defaults to DefaultCatalogEnvPrefix.
+	Prefix string
+	// Resolver resolves catalogs without environment variable. Defaults to
+	// the Giant Swarm catalogs.
+	Resolver CatalogResolver
+}
+
+// CatalogURL returns the URL of the catalog from the environment or the
+// wrapped resolver.
+func (r EnvCatalogResolver) CatalogURL(name string) (string, error) {
+	prefix := r.Prefix
+	if prefix == "" {
+		prefix = DefaultCatalogEnvPrefix
+	}
+
+	key := prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+
+	url := os.Getenv(key)
+	if url != "" {
+		return url, nil
+	}
+
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = DefaultCatalogResolver()
+	}
+
+	url, err := resolver.CatalogURL(name)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return url, nil
+}
@@ -0,0 +1,137 @@
+package apptest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// externalCatalogResolver knows no catalogs like a resolver implemented
+// outside of apptest.
+type externalCatalogResolver struct{}
+
+func (externalCatalogResolver) CatalogURL(name string) (string, error) {
+	return "", fmt.Errorf("catalog %q: %w", name, ErrCatalogNotFound)
+}
+
+func Test_CatalogResolver(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "catalogs.yaml")
+	err := ioutil.WriteFile(yamlPath, []byte("mirror: https://mirror.example.com/default-catalog/\n"), 0644) // #nosec
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	jsonPath := filepath.Join(dir, "catalogs.json")
+	err = ioutil.WriteFile(jsonPath, []byte(`{"mirror": "https://mirror.example.com/json-catalog/"}`), 0644) // #nosec
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	yamlResolver, err := NewFileCatalogResolver(yamlPath)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	jsonResolver, err := NewFileCatalogResolver(jsonPath)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	err = os.Setenv("TEST_CATALOG_DEFAULT_TEST", "https://mirror.example.com/default-test-catalog/")
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	defer os.Unsetenv("TEST_CATALOG_DEFAULT_TEST")
+
+	testCases := []struct {
+		name         string
+		resolver     CatalogResolver
+		catalog      string
+		expectedURL  string
+		errorMatcher func(error) bool
+	}{
+		{
+			name:        "case 0: default catalog",
+			resolver:    DefaultCatalogResolver(),
+			catalog:     "default",
+			expectedURL: "https://giantswarm.github.io/default-catalog/",
+		},
+		{
+			name:         "case 1: unknown catalog",
+			resolver:     DefaultCatalogResolver(),
+			catalog:      "unknown",
+			errorMatcher: IsCatalogNotFound,
+		},
+		{
+			name:        "case 2: YAML file",
+			resolver:    yamlResolver,
+			catalog:     "mirror",
+			expectedURL: "https://mirror.example.com/default-catalog/",
+		},
+		{
+			name:        "case 3: JSON file",
+			resolver:    jsonResolver,
+			catalog:     "mirror",
+			expectedURL: "https://mirror.example.com/json-catalog/",
+		},
+		{
+			name:        "case 4: chain falls back to default catalogs",
+			resolver:    ChainCatalogResolver{yamlResolver, DefaultCatalogResolver()},
+			catalog:     "giantswarm",
+			expectedURL: "https://giantswarm.github.io/giantswarm-catalog/",
+		},
+		{
+			name:        "case 5: environment variable overrides catalog",
+			resolver:    EnvCatalogResolver{Prefix: "TEST_CATALOG_"},
+			catalog:     "default-test",
+			expectedURL: "https://mirror.example.com/default-test-catalog/",
+		},
+		{
+			name:        "case 6: catalog without environment variable",
+			resolver:    EnvCatalogResolver{Prefix: "TEST_CATALOG_", Resolver: yamlResolver},
+			catalog:     "mirror",
+			expectedURL: "https://mirror.example.com/default-catalog/",
+		},
+		{
+			name:        "case 7: chain falls back from external resolver",
+			resolver:    ChainCatalogResolver{externalCatalogResolver{}, DefaultCatalogResolver()},
+			catalog:     "default",
+			expectedURL: "https://giantswarm.github.io/default-catalog/",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			url, err := tc.resolver.CatalogURL(tc.catalog)
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+
+			if url != tc.expectedURL {
+				t.Fatalf("url == %#q, want %#q", url, tc.expectedURL)
+			}
+		})
+	}
+}
+
+func Test_getCatalogURL_notFound(t *testing.T) {
+	a := newTestAppSetup(t, Config{CatalogResolver: externalCatalogResolver{}})
+
+	_, err := a.getCatalogURL(App{
+		CatalogName: "mirror",
+		Name:        "test-app",
+	})
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error got %#v", err)
+	}
+}
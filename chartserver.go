@@ -0,0 +1,539 @@
+package apptest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/microerror"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	localCatalogName = "apptest-local"
+)
+
+// chartMetadata holds the fields of Chart.yaml used in the index.
+type chartMetadata struct {
+	APIVersion  string `json:"apiVersion,omitempty"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+}
+
+// chartArchive is a packaged chart served by the chart server.
+type chartArchive struct {
+	chartMetadata
+
+	created time.Time
+	data    []byte
+	digest  string
+}
+
+type index struct {
+	APIVersion string                  `json:"apiVersion"`
+	Entries    map[string][]indexEntry `json:"entries"`
+	Generated  time.Time               `json:"generated"`
+}
+
+type indexEntry struct {
+	chartMetadata
+
+	Created time.Time `json:"created"`
+	Digest  string    `json:"digest"`
+	URLs    []string  `json:"urls"`
+}
+
+// chartServer is an in-process Helm repository serving an index.yaml and the
+// tarballs of local charts.
+type chartServer struct {
+	listener net.Listener
+	server   *http.Server
+	url      string
+
+	mutex  sync.Mutex
+	charts map[string]chartArchive
+}
+
+// newChartServer starts a Helm repository listening on the address. The
+// repository is reachable by the cluster at url, which defaults to the
+// address it is listening on. The address must be set as there is no address
+// every cluster can reach. Without url it must not be an unspecified address
+// such as 0.0.0.0.
+func newChartServer(address, url string) (*chartServer, error) {
+	if address == "" {
+		return nil, microerror.Maskf(invalidConfigError, "Config.ChartServerAddress must be set to an address reachable by the cluster to install apps from chart paths")
+	}
+
+	if url == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, microerror.Maskf(invalidConfigError, "Config.ChartServerAddress %#q is invalid: %s", address, err)
+		}
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			return nil, microerror.Maskf(invalidConfigError, "Config.ChartServerURL must be set when the chart server listens on %#q", address)
+		}
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	if url == "" {
+		url = fmt.Sprintf("http://%s", listener.Addr().String())
+	}
+
+	s := &chartServer{
+		listener: listener,
+		url:      strings.TrimSuffix(url, "/"),
+
+		charts: map[string]chartArchive{},
+	}
+	s.server = &http.Server{
+		Handler: s,
+	}
+
+	go func() {
+		_ = s.server.Serve(listener)
+	}()
+
+	return s, nil
+}
+
+// add packages the chart directory or reads the chart tarball and serves it.
+func (s *chartServer) add(chartPath string) (chartArchive, error) {
+	info, err := os.Stat(chartPath)
+	if err != nil {
+		return chartArchive{}, microerror.Mask(err)
+	}
+
+	var data []byte
+	if info.IsDir() {
+		data, err = packageChart(chartPath)
+		if err != nil {
+			return chartArchive{}, microerror.Mask(err)
+		}
+	} else {
+		data, err = ioutil.ReadFile(chartPath)
+		if err != nil {
+			return chartArchive{}, microerror.Mask(err)
+		}
+	}
+
+	metadata, err := readChartMetadata(data)
+	if err != nil {
+		return chartArchive{}, microerror.Maskf(invalidConfigError, "chart %#q: %s", chartPath, err)
+	}
+
+	chart := chartArchive{
+		chartMetadata: metadata,
+
+		created: time.Now().UTC(),
+		data:    data,
+		digest:  fmt.Sprintf("%x", sha256.Sum256(data)),
+	}
+
+	s.mutex.Lock()
+	s.charts[chartFileName(metadata.Name, metadata.Version)] = chart
+	s.mutex.Unlock()
+
+	return chart, nil
+}
+
+func (s *chartServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+
+	if name == "index.yaml" {
+		data, err := s.index()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-yaml")
+		_, _ = w.Write(data)
+		return
+	}
+
+	s.mutex.Lock()
+	chart, ok := s.charts[name]
+	s.mutex.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	_, _ = w.Write(chart.data)
+}
+
+func (s *chartServer) index() ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := index{
+		APIVersion: "v1",
+		Entries:    map[string][]indexEntry{},
+		Generated:  time.Now().UTC(),
+	}
+
+	for fileName, chart := range s.charts {
+		i.Entries[chart.Name] = append(i.Entries[chart.Name], indexEntry{
+			chartMetadata: chart.chartMetadata,
+
+			Created: chart.created,
+			Digest:  chart.digest,
+			URLs:    []string{fmt.Sprintf("%s/%s", s.url, fileName)},
+		})
+	}
+
+	for _, entries := range i.Entries {
+		sort.Slice(entries, func(a, b int) bool {
+			return entries[a].Created.After(entries[b].Created)
+		})
+	}
+
+	data, err := yaml.Marshal(i)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return data, nil
+}
+
+func (s *chartServer) close(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// packageChart creates a chart tarball from the chart directory like helm
+// package. Files matching patterns in .helmignore are skipped.
+func packageChart(dir string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var metadata chartMetadata
+	err = yaml.Unmarshal(data, &metadata)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if metadata.Name == "" {
+		return nil, microerror.Maskf(invalidConfigError, "chart %#q has no name", dir)
+	}
+
+	ignored, err := readHelmIgnore(dir)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		if rel == "." {
+			return nil
+		}
+
+		if ignored(filepath.ToSlash(rel), info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		header.Name = path.Join(metadata.Name, filepath.ToSlash(rel))
+
+		err = tarWriter.WriteHeader(header)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tarWriter, f)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	err = gzipWriter.Close()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// readHelmIgnore returns a function reporting whether a path relative to the
+// chart directory is ignored. Patterns are matched against the whole path and
+// its base name, patterns ending in a slash only match directories. Negated
+// patterns and ** are not supported and rejected so no file is packaged
+// which helm package would ignore.
+func readHelmIgnore(dir string) (func(rel string, isDir bool) bool, error) {
+	var patterns []string
+	{
+		data, err := ioutil.ReadFile(filepath.Join(dir, ".helmignore"))
+		if os.IsNotExist(err) {
+			// Fall through.
+		} else if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if strings.HasPrefix(line, "!") || strings.Contains(line, "**") {
+				return nil, microerror.Maskf(invalidConfigError, "pattern %#q in .helmignore of chart %#q is not supported, negated patterns and ** can't be used", line, dir)
+			}
+
+			patterns = append(patterns, line)
+		}
+	}
+
+	ignored := func(rel string, isDir bool) bool {
+		for _, p := range patterns {
+			if strings.HasSuffix(p, "/") {
+				if !isDir {
+					continue
+				}
+
+				p = strings.TrimSuffix(p, "/")
+			}
+
+			if ok, _ := path.Match(p, rel); ok {
+				return true
+			}
+			if ok, _ := path.Match(p, path.Base(rel)); ok {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return ignored, nil
+}
+
+// readChartMetadata reads Chart.yaml from the top level directory of the
+// chart tarball.
+func readChartMetadata(data []byte) (chartMetadata, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return chartMetadata{}, microerror.Mask(err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return chartMetadata{}, microerror.Mask(err)
+		}
+
+		parts := strings.Split(header.Name, "/")
+		if len(parts) != 2 || parts[1] != "Chart.yaml" {
+			continue
+		}
+
+		chartYAML, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return chartMetadata{}, microerror.Mask(err)
+		}
+
+		var metadata chartMetadata
+		err = yaml.Unmarshal(chartYAML, &metadata)
+		if err != nil {
+			return chartMetadata{}, microerror.Mask(err)
+		}
+		if metadata.Name == "" || metadata.Version == "" {
+			return chartMetadata{}, microerror.Maskf(invalidConfigError, "Chart.yaml must contain name and version")
+		}
+
+		return metadata, nil
+	}
+
+	return chartMetadata{}, microerror.Maskf(invalidConfigError, "Chart.yaml not found")
+}
+
+func chartFileName(name, version string) string {
+	return fmt.Sprintf("%s-%s.tgz", name, version)
+}
+
+// serveLocalCharts serves the charts of apps with a chart path and points the
+// apps at the chart server. The chart server is started on first use.
+func (a *AppSetup) serveLocalCharts(ctx context.Context, apps []App) ([]App, error) {
+	var result []App
+
+	for _, app := range apps {
+		if app.ChartPath == "" {
+			result = append(result, app)
+			continue
+		}
+
+		if app.SHA != "" {
+			return nil, microerror.Maskf(invalidConfigError, "SHA must not be set for app %#q with chart path", app.Name)
+		}
+
+		server, err := a.ensureChartServer(ctx)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		chart, err := server.add(app.ChartPath)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		// app-operator fetches the tarball named after the app.
+		if chart.Name != app.Name {
+			return nil, microerror.Maskf(invalidConfigError, "chart %#q at %#q must be named after app %#q", chart.Name, app.ChartPath, app.Name)
+		}
+		if app.Version != "" && app.Version != chart.Version {
+			return nil, microerror.Maskf(invalidConfigError, "chart %#q at %#q has version %#q but app version is %#q", chart.Name, app.ChartPath, chart.Version, app.Version)
+		}
+
+		if app.CatalogName == "" {
+			app.CatalogName = localCatalogName
+		}
+		app.CatalogURL = server.url + "/"
+		app.Version = chart.Version
+
+		err = a.checkLocalCatalog(ctx, app, apps)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		a.logger.Debugf(ctx, "serving chart %#q version %#q from %#q at %#q", chart.Name, chart.Version, app.ChartPath, server.url)
+
+		result = append(result, app)
+	}
+
+	return result, nil
+}
+
+// checkLocalCatalog ensures the catalog of the app with a chart path only
+// points at the chart server. Existing catalog CRs are not updated, so the
+// catalog must not exist with another URL, e.g. the default catalog, and
+// must not be used by apps installed from other catalogs.
+func (a *AppSetup) checkLocalCatalog(ctx context.Context, app App, apps []App) error {
+	for _, other := range apps {
+		if other.ChartPath == "" && other.CatalogName == app.CatalogName {
+			return microerror.Maskf(invalidConfigError, "catalog %#q of app %#q with chart path is also used by app %#q without chart path", app.CatalogName, app.Name, other.Name)
+		}
+	}
+
+	var catalog v1alpha1.Catalog
+	err := a.ctrlClient.Get(ctx, types.NamespacedName{Name: app.CatalogName, Namespace: a.catalogNamespace()}, &catalog)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if catalog.Spec.Storage.URL != app.CatalogURL {
+		return microerror.Maskf(invalidConfigError, "catalog %#q of app %#q with chart path already exists with URL %#q, use another catalog name", app.CatalogName, app.Name, catalog.Spec.Storage.URL)
+	}
+
+	return nil
+}
+
+func (a *AppSetup) ensureChartServer(ctx context.Context) (*chartServer, error) {
+	a.chartServerMutex.Lock()
+	defer a.chartServerMutex.Unlock()
+
+	if a.chartServer != nil {
+		return a.chartServer, nil
+	}
+
+	server, err := newChartServer(a.chartServerAddress, a.chartServerURL)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	a.logger.Debugf(ctx, "started chart server at %#q", server.url)
+
+	a.chartServer = server
+
+	return server, nil
+}
+
+func (a *AppSetup) closeChartServer(ctx context.Context) error {
+	a.chartServerMutex.Lock()
+	defer a.chartServerMutex.Unlock()
+
+	if a.chartServer == nil {
+		return nil
+	}
+
+	err := a.chartServer.close(ctx)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	a.logger.Debugf(ctx, "stopped chart server at %#q", a.chartServer.url)
+
+	a.chartServer = nil
+
+	return nil
+}
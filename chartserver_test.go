@@ -0,0 +1,284 @@
+package apptest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/appcatalog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func writeTestChart(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+
+		err := os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			t.Fatalf("expected nil got %#v", err)
+		}
+		err = ioutil.WriteFile(p, []byte(content), 0644) // #nosec
+		if err != nil {
+			t.Fatalf("expected nil got %#v", err)
+		}
+	}
+
+	return dir
+}
+
+func Test_packageChart(t *testing.T) {
+	dir := writeTestChart(t, map[string]string{
+		"Chart.yaml":                "apiVersion: v2\nname: test-app\nversion: 0.1.0\n",
+		"values.yaml":               "replicas: 1\n",
+		"templates/deployment.yaml": "kind: Deployment\n",
+		".helmignore":               "# comment\n*.swp\nci/\n",
+		"values.yaml.swp":           "",
+		"ci/test-values.yaml":       "",
+	})
+
+	data, err := packageChart(dir)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	var names []string
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("expected nil got %#v", err)
+		}
+
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+
+	expected := []string{
+		"test-app/.helmignore",
+		"test-app/Chart.yaml",
+		"test-app/templates/deployment.yaml",
+		"test-app/values.yaml",
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %v got %v", expected, names)
+		}
+	}
+
+	metadata, err := readChartMetadata(data)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if metadata.Name != "test-app" || metadata.Version != "0.1.0" {
+		t.Fatalf("expected test-app 0.1.0 got %s %s", metadata.Name, metadata.Version)
+	}
+}
+
+func Test_InstallApps_chartPath(t *testing.T) {
+	ctx := context.Background()
+
+	dir := writeTestChart(t, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: test-app\nversion: 0.1.0\n",
+	})
+
+	// The fake cluster runs in the test process.
+	a := newTestAppSetup(t, Config{ChartServerAddress: "127.0.0.1:0"})
+
+	app := App{
+		ChartPath: dir,
+		Name:      "test-app",
+		Namespace: "test",
+	}
+
+	err := a.InstallApps(ctx, []App{app})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	var catalog v1alpha1.Catalog
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: localCatalogName, Namespace: metav1.NamespaceDefault}, &catalog)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	var appCR v1alpha1.App
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: defaultNamespace}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if appCR.Spec.Catalog != localCatalogName || appCR.Spec.Version != "0.1.0" {
+		t.Fatalf("expected catalog %#q version %#q got %#q %#q", localCatalogName, "0.1.0", appCR.Spec.Catalog, appCR.Spec.Version)
+	}
+
+	version, err := appcatalog.GetLatestVersion(ctx, catalog.Spec.Storage.URL, "test-app", "")
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if version != "0.1.0" {
+		t.Fatalf("expected version %#q got %#q", "0.1.0", version)
+	}
+
+	resp, err := http.Get(catalog.Spec.Storage.URL + "test-app-0.1.0.tgz")
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	err = a.CleanUp(ctx, nil)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	_, err = http.Get(catalog.Spec.Storage.URL + "index.yaml")
+	if err == nil {
+		t.Fatalf("expected chart server to be stopped")
+	}
+}
+
+func Test_InstallApps_chartPathExistingCatalog(t *testing.T) {
+	dir := writeTestChart(t, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: test-app\nversion: 0.1.0\n",
+	})
+
+	catalog := &v1alpha1.Catalog{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default",
+			Namespace: metav1.NamespaceDefault,
+		},
+		Spec: v1alpha1.CatalogSpec{
+			Storage: v1alpha1.CatalogSpecStorage{
+				URL: "https://giantswarm.github.io/default-catalog/",
+			},
+		},
+	}
+
+	testCases := []struct {
+		name string
+		apps []App
+	}{
+		{
+			name: "case 0: catalog exists with another URL",
+			apps: []App{
+				{CatalogName: "default", ChartPath: dir, Name: "test-app", Namespace: "test"},
+			},
+		},
+		{
+			name: "case 1: catalog is used by an app without chart path",
+			apps: []App{
+				{CatalogName: "test", ChartPath: dir, Name: "test-app", Namespace: "test"},
+				{CatalogName: "test", Name: "other-app", Namespace: "test", Version: "1.0.0"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			a := newTestAppSetup(t, Config{ChartServerAddress: "127.0.0.1:0"}, catalog)
+
+			err := a.InstallApps(ctx, tc.apps)
+			if !IsInvalidConfig(err) {
+				t.Fatalf("expected invalid config error got %#v", err)
+			}
+
+			err = a.CleanUp(ctx, nil)
+			if err != nil {
+				t.Fatalf("expected nil got %#v", err)
+			}
+		})
+	}
+}
+
+func Test_packageChart_unsupportedHelmIgnore(t *testing.T) {
+	for _, pattern := range []string{"!values.yaml", "ci/**/*.yaml"} {
+		dir := writeTestChart(t, map[string]string{
+			"Chart.yaml":  "apiVersion: v2\nname: test-app\nversion: 0.1.0\n",
+			".helmignore": pattern + "\n",
+		})
+
+		_, err := packageChart(dir)
+		if !IsInvalidConfig(err) {
+			t.Fatalf("expected invalid config error for pattern %#q got %#v", pattern, err)
+		}
+	}
+}
+
+func Test_newChartServer(t *testing.T) {
+	testCases := []struct {
+		name         string
+		address      string
+		url          string
+		errorMatcher func(error) bool
+	}{
+		{
+			name:         "case 0: address must be set",
+			errorMatcher: IsInvalidConfig,
+		},
+		{
+			name:         "case 1: url must be set for unspecified address",
+			address:      "0.0.0.0:0",
+			errorMatcher: IsInvalidConfig,
+		},
+		{
+			name:    "case 2: url is set for unspecified address",
+			address: "0.0.0.0:0",
+			url:     "http://172.18.0.1:8080",
+		},
+		{
+			name:    "case 3: url defaults to address",
+			address: "127.0.0.1:0",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := newChartServer(tc.address, tc.url)
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+
+			if s != nil {
+				err = s.close(context.Background())
+				if err != nil {
+					t.Fatalf("expected nil got %#v", err)
+				}
+			}
+		})
+	}
+}
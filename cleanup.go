@@ -0,0 +1,177 @@
+package apptest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/apiextensions/v3/pkg/label"
+	"github.com/giantswarm/backoff"
+	"github.com/giantswarm/microerror"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// deleteObject deletes the object. Deleted app CRs are waited for with
+// waitForDeletedApps.
+func (a *AppSetup) deleteObject(ctx context.Context, obj inventoryObject) error {
+	var err error
+
+	a.logger.Debugf(ctx, "deleting %s", obj)
+
+	objectMeta := metav1.ObjectMeta{
+		Name:      obj.name,
+		Namespace: obj.namespace,
+	}
+
+	switch obj.kind {
+	case kindApp:
+		err = a.ctrlClient.Delete(ctx, &v1alpha1.App{ObjectMeta: objectMeta})
+	case kindAppCatalog:
+		err = a.ctrlClient.Delete(ctx, &v1alpha1.AppCatalog{ObjectMeta: objectMeta})
+	case kindCatalog:
+		err = a.ctrlClient.Delete(ctx, &v1alpha1.Catalog{ObjectMeta: objectMeta})
+	case kindConfigMap:
+		err = a.k8sClient.CoreV1().ConfigMaps(obj.namespace).Delete(ctx, obj.name, metav1.DeleteOptions{})
+	case kindNamespace:
+		err = a.k8sClient.CoreV1().Namespaces().Delete(ctx, obj.name, metav1.DeleteOptions{})
+	case kindSecret:
+		err = a.k8sClient.CoreV1().Secrets(obj.namespace).Delete(ctx, obj.name, metav1.DeleteOptions{})
+	default:
+		return microerror.Maskf(executionFailedError, "unknown kind %#q", obj.kind)
+	}
+	if apierrors.IsNotFound(err) {
+		a.logger.Debugf(ctx, "already deleted %s", obj)
+		return nil
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	a.logger.Debugf(ctx, "deleted %s", obj)
+
+	return nil
+}
+
+// waitForDeletedApps waits concurrently until the apps are deleted so
+// cleaning up several apps takes at most as long as the longest wait timeout.
+// The returned error names every app which is not deleted.
+func (a *AppSetup) waitForDeletedApps(ctx context.Context, apps []App) error {
+	errs := make([]error, len(apps))
+
+	var wg sync.WaitGroup
+	for i := range apps {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			errs[i] = a.waitForDeletedApp(ctx, apps[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var failed []string
+	var failedErrs []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("app %#q: %s", apps[i].Name, err))
+			failedErrs = append(failedErrs, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return microerror.Mask(&aggregatedError{
+			annotation: fmt.Sprintf("%d of %d apps failed to be deleted: %s", len(failed), len(apps), strings.Join(failed, "; ")),
+			errs:       failedErrs,
+		})
+	}
+
+	return nil
+}
+
+// waitForDeletedApp waits until the app CR is gone. It also waits until the
+// chart CR and the workloads and pods of the Helm release are gone in the
+// cluster the app is installed in. Workloads are annotated with the release,
+// pods labelled with it as instance.
+func (a *AppSetup) waitForDeletedApp(ctx context.Context, app App) error {
+	appCRName := appCRName(app)
+	appCRNamespace := appCRNamespace(app)
+
+	// Only the app CR is checked when the remote cluster the app is
+	// installed in can't be reached, e.g. because it was deleted already.
+	k8sClient, ctrlClient, err := a.appClients(app)
+	if err != nil {
+		a.logger.Debugf(ctx, "not waiting for workloads of app %#q: %s", app.Name, err)
+	}
+
+	a.logger.Debugf(ctx, "waiting for '%s/%s' app to be deleted", appCRNamespace, appCRName)
+
+	o := func() error {
+		err := a.ctrlClient.Get(ctx, types.NamespacedName{Name: appCRName, Namespace: appCRNamespace}, &v1alpha1.App{})
+		if apierrors.IsNotFound(err) {
+			// Fall through.
+		} else if err != nil {
+			return microerror.Mask(err)
+		} else {
+			return microerror.Maskf(executionFailedError, "app CR '%s/%s' still exists", appCRNamespace, appCRName)
+		}
+
+		if ctrlClient == nil {
+			return nil
+		}
+
+		err = ctrlClient.Get(ctx, types.NamespacedName{Name: appCRName, Namespace: defaultNamespace}, &v1alpha1.Chart{})
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			// Fall through.
+		} else if err != nil {
+			return microerror.Mask(err)
+		} else {
+			return microerror.Maskf(executionFailedError, "chart CR '%s/%s' still exists", defaultNamespace, appCRName)
+		}
+
+		if app.Namespace == "" {
+			return nil
+		}
+
+		release := appCRName
+
+		workloads, err := listWorkloads(ctx, k8sClient, app.Namespace, release)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		if len(workloads) > 0 {
+			return microerror.Maskf(executionFailedError, "%d workloads of release %#q still exist in namespace %#q", len(workloads), release, app.Namespace)
+		}
+
+		selector := labels.SelectorFromSet(labels.Set{label.AppKubernetesInstance: release}).String()
+
+		pods, err := k8sClient.CoreV1().Pods(app.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		if len(pods.Items) > 0 {
+			return microerror.Maskf(executionFailedError, "%d pods of release %#q still exist in namespace %#q", len(pods.Items), release, app.Namespace)
+		}
+
+		return nil
+	}
+
+	n := func(err error, t time.Duration) {
+		a.logger.Debugf(ctx, "waiting for '%s/%s' app to be deleted: %s", appCRNamespace, appCRName, err)
+	}
+
+	err = retryNotify(ctx, o, backoff.NewConstant(a.waitTimeout(app), a.waitInterval(app)), n)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	a.logger.Debugf(ctx, "waited for '%s/%s' app to be deleted", appCRNamespace, appCRName)
+
+	return nil
+}
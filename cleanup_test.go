@@ -0,0 +1,213 @@
+package apptest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/apiextensions/v3/pkg/label"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_CleanUp(t *testing.T) {
+	ctx := context.Background()
+
+	catalog := &v1alpha1.Catalog{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "giantswarm",
+			Namespace: metav1.NamespaceDefault,
+		},
+	}
+
+	a := newTestAppSetup(t, Config{}, catalog)
+
+	apps := []App{
+		{
+			AppCRName:   "test-app-1",
+			CatalogName: "default",
+			KubeConfig:  "apiVersion: v1",
+			Name:        "test-app",
+			Namespace:   "test",
+			ValuesYAML:  "replicas: 1",
+			Version:     "1.0.0",
+		},
+		{
+			CatalogName: "giantswarm",
+			Name:        "other-app",
+			Namespace:   "test",
+			Version:     "1.0.0",
+		},
+	}
+
+	err := a.InstallApps(ctx, apps)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	err = a.CleanUp(ctx, nil)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	for _, name := range []string{"test-app-1", "other-app"} {
+		err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: name, Namespace: defaultNamespace}, &v1alpha1.App{})
+		if !apierrors.IsNotFound(err) {
+			t.Fatalf("expected app CR %#q to be deleted got %#v", name, err)
+		}
+	}
+
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "default", Namespace: metav1.NamespaceDefault}, &v1alpha1.Catalog{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected created catalog CR to be deleted got %#v", err)
+	}
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "giantswarm", Namespace: metav1.NamespaceDefault}, &v1alpha1.Catalog{})
+	if err != nil {
+		t.Fatalf("expected existing catalog CR to be kept got %#v", err)
+	}
+
+	_, err = a.k8sClient.CoreV1().Secrets(defaultNamespace).Get(ctx, "test-app-kubeconfig", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected kubeconfig secret to be deleted got %#v", err)
+	}
+	_, err = a.k8sClient.CoreV1().ConfigMaps(defaultNamespace).Get(ctx, "test-app-user-values", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected user values config map to be deleted got %#v", err)
+	}
+
+	if len(a.inventory.list()) != 0 {
+		t.Fatalf("expected empty inventory got %v", a.inventory.list())
+	}
+}
+
+func Test_CleanUp_waitsForWorkloads(t *testing.T) {
+	ctx := context.Background()
+
+	appCR := &v1alpha1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app",
+			Namespace: defaultNamespace,
+		},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app",
+			Namespace: "test",
+			Annotations: map[string]string{
+				releaseNameAnnotation:      "test-app",
+				releaseNamespaceAnnotation: "test",
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app-1234",
+			Namespace: "test",
+			Labels: map[string]string{
+				label.AppKubernetesInstance: "test-app",
+				label.AppKubernetesName:     "test-app",
+			},
+		},
+	}
+	// Pods of other releases of the same chart are not waited for.
+	otherPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other-test-app-1234",
+			Namespace: "test",
+			Labels: map[string]string{
+				label.AppKubernetesInstance: "other-test-app",
+				label.AppKubernetesName:     "test-app",
+			},
+		},
+	}
+
+	a := newTestAppSetup(t, Config{WaitInterval: 10 * time.Millisecond, WaitTimeout: 100 * time.Millisecond}, appCR)
+	a.k8sClient = k8sfake.NewSimpleClientset(deployment, pod, otherPod)
+
+	app := App{
+		Name:      "test-app",
+		Namespace: "test",
+	}
+
+	err := a.CleanUp(ctx, []App{app})
+	if err == nil || !strings.Contains(err.Error(), "1 workloads of release `test-app`") {
+		t.Fatalf("expected workloads error got %#v", err)
+	}
+
+	err = a.k8sClient.AppsV1().Deployments("test").Delete(ctx, deployment.Name, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	err = a.CleanUp(ctx, []App{app})
+	if err == nil || !strings.Contains(err.Error(), "1 pods of release `test-app`") {
+		t.Fatalf("expected pods error got %#v", err)
+	}
+
+	err = a.k8sClient.CoreV1().Pods("test").Delete(ctx, pod.Name, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	err = a.CleanUp(ctx, []App{app})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+}
+
+func Test_CleanUp_waitsConcurrently(t *testing.T) {
+	ctx := context.Background()
+
+	var objs []runtime.Object
+	var pods []runtime.Object
+	var apps []App
+	for _, name := range []string{"test-app", "other-app", "third-app"} {
+		objs = append(objs, &v1alpha1.App{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: defaultNamespace,
+			},
+		})
+		pods = append(pods, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name + "-1234",
+				Namespace: "test",
+				Labels: map[string]string{
+					label.AppKubernetesInstance: name,
+				},
+			},
+		})
+		apps = append(apps, App{
+			Name:      name,
+			Namespace: "test",
+		})
+	}
+
+	a := newTestAppSetup(t, Config{WaitInterval: 10 * time.Millisecond, WaitTimeout: 200 * time.Millisecond}, objs...)
+	a.k8sClient = k8sfake.NewSimpleClientset(pods...)
+
+	start := time.Now()
+
+	// The pods of the apps are never deleted. The apps are waited for
+	// concurrently so clean up fails after a single wait timeout.
+	err := a.CleanUp(ctx, apps)
+	if err == nil {
+		t.Fatalf("expected error got nil")
+	}
+
+	if time.Since(start) >= 3*200*time.Millisecond {
+		t.Fatalf("expected apps to be waited for concurrently, took %s", time.Since(start))
+	}
+	for _, app := range apps {
+		if !strings.Contains(err.Error(), app.Name) {
+			t.Fatalf("expected error to name app %#q got %#q", app.Name, err.Error())
+		}
+	}
+}
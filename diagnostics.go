@@ -0,0 +1,306 @@
+package apptest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/apiextensions/v3/pkg/label"
+	"github.com/giantswarm/microerror"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultLogTailLines = 100
+	diagnosticsTimeout  = 1 * time.Minute
+)
+
+// dumpDiagnostics writes a failure report for the app to the artifacts
+// directory so CI can upload it. It gathers the app, catalog and chart CRs,
+// events in the app CR and target namespaces, the status of the app's pods
+// and the last lines of their container logs. The chart CR, pods and events
+// in the target namespace are taken from the cluster the app is installed in.
+// Errors are logged so they do not hide the deploy failure.
+func (a *AppSetup) dumpDiagnostics(ctx context.Context, app App) {
+	if a.artifactsDir == "" {
+		return
+	}
+
+	// The context may already be cancelled when waiting for the app failed.
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+
+	appCRName := appCRName(app)
+	appCRNamespace := appCRNamespace(app)
+
+	// Reports of the same app written within a second, e.g. of steps of an
+	// upgrade path, get directories of their own.
+	dir, err := reportDir(a.artifactsDir, fmt.Sprintf("%s-%s-%s-", appCRNamespace, appCRName, time.Now().UTC().Format("20060102T150405Z")))
+	if err != nil {
+		a.logger.Errorf(ctx, err, "failed to create diagnostics directory for '%s/%s' app CR", appCRNamespace, appCRName)
+		return
+	}
+
+	a.logger.Debugf(ctx, "writing diagnostics for '%s/%s' app CR to %#q", appCRNamespace, appCRName, dir)
+
+	err = a.writeDiagnostics(ctx, app, dir)
+	if err != nil {
+		a.logger.Errorf(ctx, err, "failed to write diagnostics for '%s/%s' app CR", appCRNamespace, appCRName)
+		return
+	}
+
+	a.logger.Debugf(ctx, "wrote diagnostics for '%s/%s' app CR to %#q", appCRNamespace, appCRName, dir)
+}
+
+// reportDir creates a directory with a unique name starting with the prefix
+// in the artifacts directory.
+func reportDir(artifactsDir, prefix string) (string, error) {
+	err := os.MkdirAll(artifactsDir, 0755)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	dir, err := ioutil.TempDir(artifactsDir, prefix)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	// Temporary directories are only readable by their owner.
+	err = os.Chmod(dir, 0755) // #nosec
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return dir, nil
+}
+
+func (a *AppSetup) writeDiagnostics(ctx context.Context, app App, dir string) error {
+	err := os.MkdirAll(filepath.Join(dir, "logs"), 0755)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	appCRName := appCRName(app)
+	appCRNamespace := appCRNamespace(app)
+
+	var appCR v1alpha1.App
+	{
+		err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: appCRName, Namespace: appCRNamespace}, &appCR)
+		if err != nil {
+			appCR.Name = appCRName
+			appCR.Namespace = appCRNamespace
+		}
+
+		err = writeObject(dir, "app.yaml", &appCR, err)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	{
+		catalogNamespace := appCR.Spec.CatalogNamespace
+		if catalogNamespace == "" {
+			catalogNamespace = metav1.NamespaceDefault
+		}
+
+		var catalog v1alpha1.Catalog
+
+		err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: app.CatalogName, Namespace: catalogNamespace}, &catalog)
+
+		err = writeObject(dir, "catalog.yaml", &catalog, err)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	// The chart CR, the pods and their events are in the cluster the app is
+	// installed in. Failing to reach it is written to the report like
+	// failing to get objects.
+	k8sClient, ctrlClient, clientsErr := a.appClients(app)
+
+	{
+		var chart v1alpha1.Chart
+
+		err = clientsErr
+		if err == nil {
+			err = ctrlClient.Get(ctx, types.NamespacedName{Name: appCRName, Namespace: defaultNamespace}, &chart)
+		}
+
+		err = writeObject(dir, "chart.yaml", &chart, err)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	{
+		var buf bytes.Buffer
+
+		// Only events of the app CR are relevant in the shared app CR
+		// namespace.
+		selector := fields.OneTermEqualSelector("involvedObject.name", appCRName).String()
+
+		writeEvents(ctx, &buf, a.k8sClient, appCRNamespace, selector)
+
+		if app.Namespace != "" && (isRemote(app) || app.Namespace != appCRNamespace) {
+			if clientsErr != nil {
+				fmt.Fprintf(&buf, "failed to list events in namespace %#q: %s\n", app.Namespace, clientsErr)
+			} else {
+				writeEvents(ctx, &buf, k8sClient, app.Namespace, "")
+			}
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, "events.txt"), buf.Bytes(), 0644) // #nosec
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	if app.Namespace != "" {
+		err = a.writePods(ctx, k8sClient, clientsErr, app, dir)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+func writeEvents(ctx context.Context, buf *bytes.Buffer, k8sClient kubernetes.Interface, namespace, fieldSelector string) {
+	events, err := k8sClient.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: fieldSelector})
+	if err != nil {
+		fmt.Fprintf(buf, "failed to list events in namespace %#q: %s\n", namespace, err)
+		return
+	}
+
+	fmt.Fprintf(buf, "# Events in namespace %#q\n", namespace)
+
+	for _, e := range events.Items {
+		fmt.Fprintf(buf, "%s\t%s\t%s\t%s/%s\t%s\n", e.LastTimestamp.UTC().Format(time.RFC3339), e.Type, e.Reason, e.InvolvedObject.Kind, e.InvolvedObject.Name, e.Message)
+	}
+
+	buf.WriteString("\n")
+}
+
+// writePods writes the status and container logs of the pods of the app
+// using the client of the cluster the app is installed in. clientsErr is the
+// error which occurred while getting it.
+func (a *AppSetup) writePods(ctx context.Context, k8sClient kubernetes.Interface, clientsErr error, app App, dir string) error {
+	var buf bytes.Buffer
+
+	selector := labels.SelectorFromSet(labels.Set{label.AppKubernetesName: app.Name}).String()
+
+	var pods *corev1.PodList
+	err := clientsErr
+	if err == nil {
+		pods, err = k8sClient.CoreV1().Pods(app.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	}
+	if err != nil {
+		fmt.Fprintf(&buf, "failed to list pods in namespace %#q: %s\n", app.Namespace, err)
+	} else {
+		for _, pod := range pods.Items {
+			writePodStatus(&buf, pod)
+
+			for _, c := range pod.Spec.Containers {
+				err = a.writeContainerLogs(ctx, k8sClient, pod, c.Name, dir)
+				if err != nil {
+					return microerror.Mask(err)
+				}
+			}
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "pods.txt"), buf.Bytes(), 0644) // #nosec
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (a *AppSetup) writeContainerLogs(ctx context.Context, k8sClient kubernetes.Interface, pod corev1.Pod, container, dir string) error {
+	tailLines := a.logTailLines
+
+	logs, err := k8sClient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: container,
+		TailLines: &tailLines,
+	}).DoRaw(ctx)
+	if err != nil {
+		logs = []byte(fmt.Sprintf("failed to get logs: %s\n", err))
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "logs", fmt.Sprintf("%s_%s.log", pod.Name, container)), logs, 0644) // #nosec
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func writePodStatus(buf *bytes.Buffer, pod corev1.Pod) {
+	fmt.Fprintf(buf, "# Pod %s/%s\n", pod.Namespace, pod.Name)
+	fmt.Fprintf(buf, "phase: %s\n", pod.Status.Phase)
+
+	if pod.Status.Reason != "" {
+		fmt.Fprintf(buf, "reason: %s, message: %s\n", pod.Status.Reason, pod.Status.Message)
+	}
+
+	for _, c := range pod.Status.Conditions {
+		fmt.Fprintf(buf, "condition %s: %s %s\n", c.Type, c.Status, c.Message)
+	}
+
+	for _, c := range pod.Status.ContainerStatuses {
+		var state []string
+		if c.State.Waiting != nil {
+			state = append(state, fmt.Sprintf("waiting %s: %s", c.State.Waiting.Reason, c.State.Waiting.Message))
+		}
+		if c.State.Running != nil {
+			state = append(state, "running")
+		}
+		if c.State.Terminated != nil {
+			state = append(state, fmt.Sprintf("terminated %s (exit code %d): %s", c.State.Terminated.Reason, c.State.Terminated.ExitCode, c.State.Terminated.Message))
+		}
+
+		fmt.Fprintf(buf, "container %s: ready %t, restarts %d, %s\n", c.Name, c.Ready, c.RestartCount, strings.Join(state, ", "))
+	}
+
+	buf.WriteString("\n")
+}
+
+// writeObject writes the object as YAML or the error which occurred while
+// getting it.
+func writeObject(dir, name string, obj runtime.Object, getErr error) error {
+	var data []byte
+	if apierrors.IsNotFound(getErr) {
+		data = []byte(fmt.Sprintf("# not found: %s\n", getErr))
+	} else if getErr != nil {
+		data = []byte(fmt.Sprintf("# failed to get: %s\n", getErr))
+	} else {
+		var err error
+
+		data, err = yaml.Marshal(obj)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644) // #nosec
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
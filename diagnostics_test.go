@@ -0,0 +1,216 @@
+package apptest
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/apiextensions/v3/pkg/label"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_dumpDiagnostics(t *testing.T) {
+	ctx := context.Background()
+
+	artifactsDir := t.TempDir()
+
+	app := App{
+		CatalogName: "default",
+		Name:        "test-app",
+		Namespace:   "test",
+	}
+
+	appCR := &v1alpha1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app",
+			Namespace: defaultNamespace,
+		},
+		Spec: v1alpha1.AppSpec{
+			KubeConfig: v1alpha1.AppSpecKubeConfig{
+				InCluster: true,
+			},
+		},
+		Status: v1alpha1.AppStatus{
+			Release: v1alpha1.AppStatusRelease{
+				Reason: "chart not found",
+				Status: failedStatus,
+			},
+		},
+	}
+	catalog := &v1alpha1.Catalog{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default",
+			Namespace: metav1.NamespaceDefault,
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app-1234",
+			Namespace: "test",
+			Labels: map[string]string{
+				label.AppKubernetesName: "test-app",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "test-app"},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name: "test-app",
+					State: corev1.ContainerState{
+						Waiting: &corev1.ContainerStateWaiting{
+							Reason: "ImagePullBackOff",
+						},
+					},
+				},
+			},
+		},
+	}
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app-1234.1",
+			Namespace: "test",
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind: "Pod",
+			Name: "test-app-1234",
+		},
+		Message: "Back-off pulling image",
+		Reason:  "BackOff",
+		Type:    corev1.EventTypeWarning,
+	}
+
+	a := newTestAppSetup(t, Config{ArtifactsDir: artifactsDir}, appCR, catalog)
+	a.k8sClient = k8sfake.NewSimpleClientset(pod, event)
+
+	// Reports written within the same second don't overwrite each other.
+	a.dumpDiagnostics(ctx, app)
+	a.dumpDiagnostics(ctx, app)
+
+	dirs, err := filepath.Glob(filepath.Join(artifactsDir, "giantswarm-test-app-*"))
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if len(dirs) != 2 {
+		t.Fatalf("expected 2 report directories got %d", len(dirs))
+	}
+
+	expectedContents := map[string]string{
+		"app.yaml":                        "chart not found",
+		"catalog.yaml":                    "name: default",
+		"chart.yaml":                      "not found",
+		"events.txt":                      "Back-off pulling image",
+		"pods.txt":                        "waiting ImagePullBackOff",
+		"logs/test-app-1234_test-app.log": "fake logs",
+	}
+
+	for name, expected := range expectedContents {
+		data, err := ioutil.ReadFile(filepath.Join(dirs[0], name))
+		if err != nil {
+			t.Fatalf("expected nil got %#v", err)
+		}
+
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expected %#q to contain %#q got %#q", name, expected, string(data))
+		}
+	}
+}
+
+func Test_dumpDiagnostics_remote(t *testing.T) {
+	ctx := context.Background()
+
+	artifactsDir := t.TempDir()
+
+	app := App{
+		CatalogName:       "default",
+		KubeConfigContext: "admin@workload",
+		KubeConfigPath:    writeTestKubeConfig(t),
+		Name:              "test-app",
+		Namespace:         "test",
+	}
+
+	// The pod and its events are in the workload cluster. The management
+	// cluster has a pod with the same labels which must not be reported.
+	workloadPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app-workload",
+			Namespace: "test",
+			Labels: map[string]string{
+				label.AppKubernetesName: "test-app",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "test-app"},
+			},
+		},
+	}
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app-workload.1",
+			Namespace: "test",
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind: "Pod",
+			Name: "test-app-workload",
+		},
+		Message: "Back-off pulling image",
+		Reason:  "BackOff",
+		Type:    corev1.EventTypeWarning,
+	}
+	managementPod := workloadPod.DeepCopy()
+	managementPod.Name = "test-app-management"
+
+	a := newTestAppSetup(t, Config{ArtifactsDir: artifactsDir})
+	a.k8sClient = k8sfake.NewSimpleClientset(managementPod)
+
+	kubeConfig, contextName, err := remoteKubeConfig(app)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	a.workloadClients[workloadClientsKey(kubeConfig, contextName)] = &WorkloadClients{
+		CtrlClient: a.ctrlClient,
+		K8sClient:  k8sfake.NewSimpleClientset(workloadPod, event),
+	}
+
+	a.dumpDiagnostics(ctx, app)
+
+	dirs, err := filepath.Glob(filepath.Join(artifactsDir, "giantswarm-test-app-*"))
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if len(dirs) != 1 {
+		t.Fatalf("expected 1 report directory got %d", len(dirs))
+	}
+
+	pods, err := ioutil.ReadFile(filepath.Join(dirs[0], "pods.txt"))
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if !strings.Contains(string(pods), "test-app-workload") || strings.Contains(string(pods), "test-app-management") {
+		t.Fatalf("expected pods of the workload cluster got %#q", string(pods))
+	}
+
+	events, err := ioutil.ReadFile(filepath.Join(dirs[0], "events.txt"))
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if !strings.Contains(string(events), "Back-off pulling image") {
+		t.Fatalf("expected events of the workload cluster got %#q", string(events))
+	}
+
+	_, err = ioutil.ReadFile(filepath.Join(dirs[0], "logs", "test-app-workload_test-app.log"))
+	if err != nil {
+		t.Fatalf("expected logs of the workload pod got %#v", err)
+	}
+}
@@ -1,6 +1,56 @@
 package apptest
 
-import "github.com/giantswarm/microerror"
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+)
+
+var appFailedError = &microerror.Error{
+	Kind: "appFailedError",
+}
+
+// IsAppFailed asserts appFailedError. It is returned when the app CR of an
+// app has status failed or not-installed. It also asserts
+// terminalStatusError.
+func IsAppFailed(err error) bool {
+	return errors.Is(err, appFailedError) || IsTerminalStatus(err)
+}
+
+// ErrCatalogNotFound is returned by a CatalogResolver for catalogs it
+// doesn't know. Resolvers outside of apptest can return it, also wrapped,
+// e.g. with microerror.Maskf or fmt.Errorf and %w.
+var ErrCatalogNotFound = &microerror.Error{
+	Kind: "catalogNotFoundError",
+}
+
+// IsCatalogNotFound asserts ErrCatalogNotFound.
+func IsCatalogNotFound(err error) bool {
+	return errors.Is(err, ErrCatalogNotFound)
+}
+
+var catalogUnreachableError = &microerror.Error{
+	Kind: "catalogUnreachableError",
+}
+
+// IsCatalogUnreachable asserts catalogUnreachableError. It is returned when
+// the index or tags of a catalog can't be fetched.
+func IsCatalogUnreachable(err error) bool {
+	return errors.Is(err, catalogUnreachableError)
+}
+
+var conflictError = &microerror.Error{
+	Kind: "conflictError",
+}
+
+// IsConflict asserts conflictError. It is returned when an object apptest
+// applies has fields managed by other field managers with other values. The
+// fields are not overwritten, the error names the object and the managers.
+func IsConflict(err error) bool {
+	return errors.Is(err, conflictError)
+}
 
 var executionFailedError = &microerror.Error{
 	Kind: "executionFailedError",
@@ -12,7 +62,7 @@ var invalidConfigError = &microerror.Error{
 
 // IsInvalidConfig asserts invalidConfigError.
 func IsInvalidConfig(err error) bool {
-	return microerror.Cause(err) == invalidConfigError
+	return errors.Is(err, invalidConfigError)
 }
 
 var notFoundError = &microerror.Error{
@@ -21,5 +71,196 @@ var notFoundError = &microerror.Error{
 
 // IsNotFound asserts notFoundError.
 func IsNotFound(err error) bool {
-	return microerror.Cause(err) == notFoundError
+	return errors.Is(err, notFoundError)
+}
+
+var resourcesLeftError = &microerror.Error{
+	Kind: "resourcesLeftError",
+}
+
+// IsResourcesLeft asserts resourcesLeftError. It is returned by UninstallApp
+// when resources of the app are left behind once it is uninstalled.
+func IsResourcesLeft(err error) bool {
+	return errors.Is(err, resourcesLeftError)
+}
+
+var terminalStatusError = &microerror.Error{
+	Kind: "terminalStatusError",
+}
+
+// IsTerminalStatus asserts terminalStatusError. It is returned when the app
+// CR of an app matches a terminal status, e.g. because its chart doesn't
+// exist or it is stuck in pending-install.
+func IsTerminalStatus(err error) bool {
+	return errors.Is(err, terminalStatusError)
+}
+
+var versionNotFoundError = &microerror.Error{
+	Kind: "versionNotFoundError",
+}
+
+// IsVersionNotFound asserts versionNotFoundError. It is returned when no
+// version of an app in its catalog matches the desired version or SHA.
+func IsVersionNotFound(err error) bool {
+	return errors.Is(err, versionNotFoundError)
+}
+
+var waitTimeoutError = &microerror.Error{
+	Kind: "waitTimeoutError",
+}
+
+// IsWaitTimeout asserts waitTimeoutError. It is returned when an app is not
+// deployed within its wait timeout or the context is done.
+func IsWaitTimeout(err error) bool {
+	return errors.Is(err, waitTimeoutError)
+}
+
+var workloadFailedError = &microerror.Error{
+	Kind: "workloadFailedError",
+}
+
+// IsWorkloadFailed asserts workloadFailedError. It is returned when a pod of
+// an app waited for with WaitForReady can't start, e.g. because of
+// ImagePullBackOff or CrashLoopBackOff, or when one of its jobs failed.
+func IsWorkloadFailed(err error) bool {
+	return errors.Is(err, workloadFailedError)
+}
+
+// AppError is the error of a single app. Use errors.As to get the name of the
+// app and the release status and reason its app CR had when it failed. Its
+// kind is asserted with IsAppFailed, IsCatalogUnreachable, IsTerminalStatus,
+// IsVersionNotFound, IsWaitTimeout and IsWorkloadFailed.
+type AppError struct {
+	// Name is the name of the app.
+	Name string
+	// Status is the release status of the app CR. It is empty when the app
+	// CR was not created yet.
+	Status string
+	// Reason is the release reason of the app CR.
+	Reason string
+
+	annotation string
+	kind       *microerror.Error
+}
+
+func newAppError(kind *microerror.Error, name, status, reason string, f string, v ...interface{}) *AppError {
+	return &AppError{
+		Name:   name,
+		Status: status,
+		Reason: reason,
+
+		annotation: fmt.Sprintf(f, v...),
+		kind:       kind,
+	}
+}
+
+// NewAppFailedError returns the AppError of an app whose app CR has status
+// failed or not-installed, e.g. for fake implementations of Interface. It is
+// asserted by IsAppFailed.
+func NewAppFailedError(name, status, reason string) *AppError {
+	return newAppError(appFailedError, name, status, reason, "status %#q, reason: %s", status, reason)
+}
+
+// NewTerminalStatusError returns the AppError of an app whose app CR has a
+// terminal status or reason. It is asserted by IsTerminalStatus and
+// IsAppFailed.
+func NewTerminalStatusError(name, status, reason string) *AppError {
+	return newAppError(terminalStatusError, name, status, reason, "terminal status %#q, reason: %s", status, reason)
+}
+
+// NewWaitTimeoutError returns the AppError of an app which was not deployed
+// in time. Its app CR had the given status and reason last. It is asserted
+// by IsWaitTimeout.
+func NewWaitTimeoutError(name, status, reason string) *AppError {
+	return newAppError(waitTimeoutError, name, status, reason, "app %#q is not %#q, current %#q", name, deployedStatus, status)
+}
+
+// NewInvalidConfigError returns an error asserted by IsInvalidConfig with
+// the formatted message, e.g. for fake implementations of Interface.
+func NewInvalidConfigError(f string, v ...interface{}) error {
+	return microerror.Maskf(invalidConfigError, f, v...)
+}
+
+func (e *AppError) Error() string {
+	return e.kind.Error() + ": " + e.annotation
+}
+
+func (e *AppError) Unwrap() error {
+	return e.kind
+}
+
+// Leftover identifies a resource of an app which was left behind once the
+// app was uninstalled.
+type Leftover struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (l Leftover) String() string {
+	if l.Namespace == "" {
+		return fmt.Sprintf("%s %#q", l.Kind, l.Name)
+	}
+
+	return fmt.Sprintf("%s '%s/%s'", l.Kind, l.Namespace, l.Name)
+}
+
+// LeftoverError is returned by UninstallApp when resources of the app are
+// left behind. Use errors.As to get the resources. Its kind is asserted with
+// IsResourcesLeft.
+type LeftoverError struct {
+	// Name is the name of the app.
+	Name string
+	// Leftovers are the resources left behind, e.g. CRDs kept by Helm,
+	// persistent volume claims of stateful sets or namespaces.
+	Leftovers []Leftover
+}
+
+func (e *LeftoverError) Error() string {
+	leftovers := make([]string, len(e.Leftovers))
+	for i, l := range e.Leftovers {
+		leftovers[i] = l.String()
+	}
+
+	return fmt.Sprintf("%s: %d resources of app %#q left behind: %s", resourcesLeftError.Error(), len(e.Leftovers), e.Name, strings.Join(leftovers, ", "))
+}
+
+func (e *LeftoverError) Unwrap() error {
+	return resourcesLeftError
+}
+
+// aggregatedError is an execution failed error caused by one or more errors,
+// e.g. of every app which failed to install. The kinds of all of them can be
+// asserted.
+type aggregatedError struct {
+	annotation string
+	errs       []error
+}
+
+func (e *aggregatedError) Error() string {
+	return executionFailedError.Error() + ": " + e.annotation
+}
+
+func (e *aggregatedError) Unwrap() error {
+	return executionFailedError
+}
+
+func (e *aggregatedError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *aggregatedError) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
 }
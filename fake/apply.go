@@ -0,0 +1,32 @@
+package fake
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/apptest/internal/applyfake"
+)
+
+const (
+	// fieldManager is the field manager of the applies of fake clientsets,
+	// the one apptest uses.
+	fieldManager = "apptest"
+)
+
+// NewApplyClient wraps a fake controller-runtime client so it supports the
+// server-side apply patches apptest uses. Applied objects are stored as the
+// types registered in scheme, their status is left untouched. Fields owned
+// by other field managers with other values conflict unless the apply is
+// forced.
+func NewApplyClient(c client.Client, scheme *runtime.Scheme) client.Client {
+	return applyfake.NewClient(c, scheme)
+}
+
+// AddApplyReactor makes the fake clientset support server-side apply patches
+// like NewApplyClient. Patch options aren't passed to the reactors of fake
+// clientsets, so every apply is made by the `apptest` field manager without
+// forcing like apptest does.
+func AddApplyReactor(clientset *k8sfake.Clientset) {
+	applyfake.AddReactor(clientset, fieldManager)
+}
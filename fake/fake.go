@@ -0,0 +1,578 @@
+// Package fake provides an in-memory implementation of apptest.Interface for
+// unit tests. Calls are recorded and App CR status transitions can be
+// scripted so code depending on apptest can be tested without a cluster.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/apiextensions/v3/pkg/label"
+	"github.com/giantswarm/microerror"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/giantswarm/apptest"
+	"github.com/giantswarm/apptest/internal/chartvalues"
+)
+
+const (
+	// StatusDeployed is the release status of a successfully deployed app.
+	StatusDeployed = "deployed"
+	// StatusFailed is the release status of an app that failed to deploy.
+	StatusFailed = "failed"
+	// StatusNotInstalled is the release status of an app that could not be
+	// installed.
+	StatusNotInstalled = "not-installed"
+	// StatusPendingInstall is the release status of an app that is still
+	// being installed.
+	StatusPendingInstall = "pending-install"
+)
+
+const (
+	defaultNamespace = "giantswarm"
+)
+
+// Config represents the configuration used to create a fake app setup.
+type Config struct {
+	// CtrlObjects are loaded into the fake controller-runtime client.
+	CtrlObjects []runtime.Object
+	// K8sObjects are loaded into the fake clientset.
+	K8sObjects []runtime.Object
+	// RESTConfig is returned by RESTConfig. Defaults to an empty config.
+	RESTConfig *rest.Config
+	// Scheme is used by the fake controller-runtime client. The app platform
+	// types are added to it.
+	Scheme *runtime.Scheme
+}
+
+// Calls holds the arguments of every call made to the fake app setup.
+type Calls struct {
+	CleanUp      [][]apptest.App
+	EnsureCRDs   [][]*apiextensionsv1.CustomResourceDefinition
+	InstallApps  [][]apptest.App
+	MergedValues []apptest.App
+	RollbackApp  []UpgradeAppCall
+	UninstallApp []apptest.App
+	UpgradeApp   []UpgradeAppCall
+	UpgradePath  [][]apptest.App
+}
+
+// UpgradeAppCall holds the arguments of a single UpgradeApp or RollbackApp
+// call.
+type UpgradeAppCall struct {
+	Current apptest.App
+	Desired apptest.App
+}
+
+// Transition is a status the fake app platform sets on an App CR.
+type Transition struct {
+	Status string
+	Reason string
+	// Version is set as the App CR status version. Defaults to the version
+	// of the app being waited for.
+	Version string
+}
+
+// AppSetup is an in-memory implementation of apptest.Interface.
+type AppSetup struct {
+	ctrlClient client.Client
+	k8sClient  kubernetes.Interface
+	restConfig *rest.Config
+
+	mutex   sync.Mutex
+	calls   Calls
+	scripts map[string][][]Transition
+}
+
+var _ apptest.Interface = &AppSetup{}
+
+// New creates a new fake app setup backed by fake clients.
+func New(config Config) (*AppSetup, error) {
+	var err error
+
+	if config.Scheme == nil {
+		config.Scheme = runtime.NewScheme()
+
+		err = clientgoscheme.AddToScheme(config.Scheme)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+	if config.RESTConfig == nil {
+		config.RESTConfig = &rest.Config{}
+	}
+
+	appSchemeBuilder := runtime.SchemeBuilder{
+		v1alpha1.AddToScheme,
+		apiextensionsv1.AddToScheme,
+	}
+	err = appSchemeBuilder.AddToScheme(config.Scheme)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	k8sClient := k8sfake.NewSimpleClientset(config.K8sObjects...)
+	AddApplyReactor(k8sClient)
+
+	a := &AppSetup{
+		ctrlClient: NewApplyClient(ctrlfake.NewFakeClientWithScheme(config.Scheme, config.CtrlObjects...), config.Scheme),
+		k8sClient:  k8sClient,
+		restConfig: config.RESTConfig,
+
+		scripts: map[string][][]Transition{},
+	}
+
+	return a, nil
+}
+
+// ScriptApp queues the status transitions applied to the App CR of the app
+// with the given name the next time it is waited for. Every wait consumes one
+// script, so calling ScriptApp twice scripts e.g. both steps of UpgradeApp.
+// Without a script the App CR is marked as deployed right away.
+func (a *AppSetup) ScriptApp(name string, transitions ...Transition) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.scripts[name] = append(a.scripts[name], transitions)
+}
+
+// Calls returns the arguments of every call made so far.
+func (a *AppSetup) Calls() Calls {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	c := Calls{
+		CleanUp:      append([][]apptest.App{}, a.calls.CleanUp...),
+		EnsureCRDs:   append([][]*apiextensionsv1.CustomResourceDefinition{}, a.calls.EnsureCRDs...),
+		InstallApps:  append([][]apptest.App{}, a.calls.InstallApps...),
+		MergedValues: append([]apptest.App{}, a.calls.MergedValues...),
+		RollbackApp:  append([]UpgradeAppCall{}, a.calls.RollbackApp...),
+		UninstallApp: append([]apptest.App{}, a.calls.UninstallApp...),
+		UpgradeApp:   append([]UpgradeAppCall{}, a.calls.UpgradeApp...),
+		UpgradePath:  append([][]apptest.App{}, a.calls.UpgradePath...),
+	}
+
+	return c
+}
+
+// InstallApps creates App CRs in the fake controller-runtime client and
+// applies the scripted status transitions.
+func (a *AppSetup) InstallApps(ctx context.Context, apps []apptest.App) error {
+	_, err := a.InstallAppsWithResult(ctx, apps)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// InstallAppsWithResult installs the apps like InstallApps and returns the
+// installed apps with the release status of their App CRs. Version
+// constraints are not resolved.
+func (a *AppSetup) InstallAppsWithResult(ctx context.Context, apps []apptest.App) ([]apptest.InstalledApp, error) {
+	a.mutex.Lock()
+	a.calls.InstallApps = append(a.calls.InstallApps, apps)
+	a.mutex.Unlock()
+
+	var installed []apptest.InstalledApp
+	for _, app := range apps {
+		err := a.ensureApp(ctx, app)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		installed = append(installed, apptest.InstalledApp{
+			App:        app,
+			Version:    appVersion(app),
+			CatalogURL: app.CatalogURL,
+			AppCR: client.ObjectKey{
+				Name:      appCRName(app),
+				Namespace: appCRNamespace(app),
+			},
+		})
+	}
+
+	for i, app := range apps {
+		start := time.Now()
+
+		// Failures of apps that are not waited for are only visible in the
+		// App CR status.
+		err := a.transitionApp(ctx, app)
+		if err != nil && (app.WaitForDeploy || app.WaitForReady) {
+			return nil, microerror.Mask(err)
+		}
+
+		var current v1alpha1.App
+		err = a.ctrlClient.Get(ctx, installed[i].AppCR, &current)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		installed[i].Duration = time.Since(start)
+		installed[i].Release = current.Status.Release
+	}
+
+	return installed, nil
+}
+
+// UpgradeApp creates the current App CR, waits for it and updates it to the
+// desired version.
+func (a *AppSetup) UpgradeApp(ctx context.Context, current, desired apptest.App) error {
+	a.mutex.Lock()
+	a.calls.UpgradeApp = append(a.calls.UpgradeApp, UpgradeAppCall{Current: current, Desired: desired})
+	a.mutex.Unlock()
+
+	err := a.applySteps(ctx, []apptest.App{current, desired})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// UpgradePath creates the App CR of the first step and updates it to every
+// following step, applying the scripted status transitions after each step.
+func (a *AppSetup) UpgradePath(ctx context.Context, steps []apptest.App) error {
+	a.mutex.Lock()
+	a.calls.UpgradePath = append(a.calls.UpgradePath, steps)
+	a.mutex.Unlock()
+
+	if len(steps) < 2 {
+		return microerror.Mask(apptest.NewInvalidConfigError("upgrade path must have at least 2 steps, got %d", len(steps)))
+	}
+
+	err := a.applySteps(ctx, steps)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// RollbackApp creates the current App CR, updates it to the desired version
+// and back to the current version.
+func (a *AppSetup) RollbackApp(ctx context.Context, current, desired apptest.App) error {
+	a.mutex.Lock()
+	a.calls.RollbackApp = append(a.calls.RollbackApp, UpgradeAppCall{Current: current, Desired: desired})
+	a.mutex.Unlock()
+
+	err := a.applySteps(ctx, []apptest.App{current, desired, current})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// EnsureCRDs creates the CRDs in the fake controller-runtime client and marks
+// them as established.
+func (a *AppSetup) EnsureCRDs(ctx context.Context, crds []*apiextensionsv1.CustomResourceDefinition) error {
+	a.mutex.Lock()
+	a.calls.EnsureCRDs = append(a.calls.EnsureCRDs, crds)
+	a.mutex.Unlock()
+
+	for _, crd := range crds {
+		c := crd.DeepCopy()
+		c.Status.Conditions = append(c.Status.Conditions, apiextensionsv1.CustomResourceDefinitionCondition{
+			Type:   apiextensionsv1.Established,
+			Status: apiextensionsv1.ConditionTrue,
+		})
+
+		err := a.ctrlClient.Create(ctx, c)
+		if apierrors.IsAlreadyExists(err) {
+			// It's ok.
+		} else if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+// K8sClient returns the fake Kubernetes clientset.
+func (a *AppSetup) K8sClient() kubernetes.Interface {
+	return a.k8sClient
+}
+
+// CtrlClient returns the fake controller-runtime client.
+func (a *AppSetup) CtrlClient() client.Client {
+	return a.ctrlClient
+}
+
+// RESTConfig returns the configured REST config.
+func (a *AppSetup) RESTConfig() *rest.Config {
+	return a.restConfig
+}
+
+// WorkloadClients returns the configured clients. The fake app platform
+// installs apps in remote clusters in the same fake cluster.
+func (a *AppSetup) WorkloadClients(app apptest.App) (*apptest.WorkloadClients, error) {
+	c := &apptest.WorkloadClients{
+		CtrlClient: a.ctrlClient,
+		K8sClient:  a.k8sClient,
+		RESTConfig: a.restConfig,
+	}
+
+	return c, nil
+}
+
+// UninstallApp deletes the App CR. The fake app platform has no Helm release
+// so nothing is left behind.
+func (a *AppSetup) UninstallApp(ctx context.Context, app apptest.App) error {
+	a.mutex.Lock()
+	a.calls.UninstallApp = append(a.calls.UninstallApp, app)
+	a.mutex.Unlock()
+
+	err := a.ctrlClient.Delete(ctx, &v1alpha1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      appCRName(app),
+			Namespace: appCRNamespace(app),
+		},
+	})
+	if apierrors.IsNotFound(err) {
+		// It's ok.
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// CleanUp deletes the App CRs of the given apps.
+func (a *AppSetup) CleanUp(ctx context.Context, apps []apptest.App) error {
+	a.mutex.Lock()
+	a.calls.CleanUp = append(a.calls.CleanUp, apps)
+	a.mutex.Unlock()
+
+	for _, app := range apps {
+		err := a.ctrlClient.Delete(ctx, &v1alpha1.App{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      appCRName(app),
+				Namespace: appCRNamespace(app),
+			},
+		})
+		if apierrors.IsNotFound(err) {
+			// It's ok.
+		} else if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+// MergedValues returns the user values of the app with its secret values
+// merged over them. The fake app platform has no catalog or cluster values.
+func (a *AppSetup) MergedValues(ctx context.Context, app apptest.App) (map[string]interface{}, error) {
+	a.mutex.Lock()
+	a.calls.MergedValues = append(a.calls.MergedValues, app)
+	a.mutex.Unlock()
+
+	values := map[string]interface{}{}
+
+	for _, v := range []struct {
+		yaml   string
+		values interface{}
+	}{
+		{yaml: app.ValuesYAML, values: app.Values},
+		{yaml: app.SecretValuesYAML, values: app.SecretValues},
+	} {
+		data := []byte(v.yaml)
+		if v.values != nil {
+			var err error
+			data, err = yaml.Marshal(v.values)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+		}
+
+		var parsed map[string]interface{}
+		err := yaml.Unmarshal(data, &parsed)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		chartvalues.Merge(values, parsed)
+	}
+
+	return values, nil
+}
+
+func (a *AppSetup) applySteps(ctx context.Context, steps []apptest.App) error {
+	for i, step := range steps {
+		err := a.ensureApp(ctx, step)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		err = a.transitionApp(ctx, step)
+		if err != nil {
+			return microerror.Mask(fmt.Errorf("step %d of %d (app %#q version %#q) failed: %w", i+1, len(steps), step.Name, appVersion(step), err))
+		}
+	}
+
+	return nil
+}
+
+func (a *AppSetup) ensureApp(ctx context.Context, app apptest.App) error {
+	var current v1alpha1.App
+
+	err := a.ctrlClient.Get(ctx, types.NamespacedName{Name: appCRName(app), Namespace: appCRNamespace(app)}, &current)
+	if apierrors.IsNotFound(err) {
+		appCR := &v1alpha1.App{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      appCRName(app),
+				Namespace: appCRNamespace(app),
+				Labels: map[string]string{
+					label.AppKubernetesName: app.Name,
+				},
+			},
+			Spec: v1alpha1.AppSpec{
+				Catalog:   app.CatalogName,
+				Name:      app.Name,
+				Namespace: app.Namespace,
+				Version:   appVersion(app),
+			},
+		}
+
+		err = a.ctrlClient.Create(ctx, appCR)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		return nil
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	current.Spec.Catalog = app.CatalogName
+	current.Spec.Version = appVersion(app)
+
+	err = a.ctrlClient.Update(ctx, &current)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (a *AppSetup) transitionApp(ctx context.Context, app apptest.App) error {
+	var transitions []Transition
+	{
+		a.mutex.Lock()
+		scripts := a.scripts[app.Name]
+		if len(scripts) > 0 {
+			transitions = scripts[0]
+			a.scripts[app.Name] = scripts[1:]
+		} else {
+			transitions = []Transition{{Status: StatusDeployed}}
+		}
+		a.mutex.Unlock()
+	}
+
+	var last Transition
+	for _, t := range transitions {
+		var current v1alpha1.App
+
+		err := a.ctrlClient.Get(ctx, types.NamespacedName{Name: appCRName(app), Namespace: appCRNamespace(app)}, &current)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		version := t.Version
+		if version == "" {
+			version = appVersion(app)
+		}
+
+		current.Status.Version = version
+		current.Status.Release.Status = t.Status
+		current.Status.Release.Reason = t.Reason
+		if t.Status == StatusDeployed {
+			current.Status.Release.LastDeployed = metav1.Now()
+		}
+
+		err = a.ctrlClient.Status().Update(ctx, &current)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		last = t
+
+		switch {
+		case t.Status == StatusFailed || t.Status == StatusNotInstalled:
+			return microerror.Mask(apptest.NewAppFailedError(app.Name, t.Status, t.Reason))
+		case isTerminal(t):
+			return microerror.Mask(apptest.NewTerminalStatusError(app.Name, t.Status, t.Reason))
+		}
+	}
+
+	if last.Status != StatusDeployed {
+		return microerror.Mask(apptest.NewWaitTimeoutError(app.Name, last.Status, last.Reason))
+	}
+
+	return nil
+}
+
+// isTerminal returns whether the transition matches one of the default
+// terminal statuses of apptest which are terminal right away.
+func isTerminal(t Transition) bool {
+	for _, s := range apptest.DefaultTerminalStatuses() {
+		if s.StuckAfter > 0 {
+			continue
+		}
+		if s.Status != "" && s.Status != t.Status {
+			continue
+		}
+		if s.Reason != "" && !regexp.MustCompile(s.Reason).MatchString(t.Reason) {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func appCRName(app apptest.App) string {
+	if app.AppCRName != "" {
+		return app.AppCRName
+	}
+
+	return app.Name
+}
+
+func appCRNamespace(app apptest.App) string {
+	if app.AppCRNamespace != "" {
+		return app.AppCRNamespace
+	}
+
+	return defaultNamespace
+}
+
+// appVersion returns the version the fake app platform reports for the app.
+// Test catalog versions have the format [latest version]-[sha].
+func appVersion(app apptest.App) string {
+	if app.Version != "" {
+		return app.Version
+	}
+	if app.SHA != "" {
+		return fmt.Sprintf("0.0.0-%s", app.SHA)
+	}
+
+	return ""
+}
@@ -0,0 +1,268 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/giantswarm/apptest"
+)
+
+func Test_InstallApps(t *testing.T) {
+	testCases := []struct {
+		name           string
+		transitions    []Transition
+		waitForDeploy  bool
+		expectedStatus string
+		errorMatcher   func(error) bool
+	}{
+		{
+			name:           "case 0: unscripted app is deployed",
+			waitForDeploy:  true,
+			expectedStatus: StatusDeployed,
+		},
+		{
+			name: "case 1: app is deployed after pending install",
+			transitions: []Transition{
+				{Status: StatusPendingInstall},
+				{Status: StatusDeployed},
+			},
+			waitForDeploy:  true,
+			expectedStatus: StatusDeployed,
+		},
+		{
+			name: "case 2: failed app returns error",
+			transitions: []Transition{
+				{Status: StatusPendingInstall},
+				{Status: StatusFailed, Reason: "chart not found"},
+			},
+			waitForDeploy:  true,
+			expectedStatus: StatusFailed,
+			errorMatcher:   apptest.IsAppFailed,
+		},
+		{
+			name: "case 3: app stuck in pending install returns error",
+			transitions: []Transition{
+				{Status: StatusPendingInstall},
+			},
+			waitForDeploy:  true,
+			expectedStatus: StatusPendingInstall,
+			errorMatcher:   apptest.IsWaitTimeout,
+		},
+		{
+			name: "case 4: not installed app without wait returns no error",
+			transitions: []Transition{
+				{Status: StatusNotInstalled},
+			},
+			expectedStatus: StatusNotInstalled,
+		},
+		{
+			name: "case 5: app with terminal status returns error",
+			transitions: []Transition{
+				{Status: "chart-pull-failed"},
+			},
+			waitForDeploy:  true,
+			expectedStatus: "chart-pull-failed",
+			errorMatcher:   apptest.IsTerminalStatus,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			a, err := New(Config{})
+			if err != nil {
+				t.Fatalf("expected nil got %#v", err)
+			}
+
+			if len(tc.transitions) > 0 {
+				a.ScriptApp("test-app", tc.transitions...)
+			}
+
+			apps := []apptest.App{
+				{
+					CatalogName:   "default",
+					Name:          "test-app",
+					Namespace:     "giantswarm",
+					Version:       "1.0.0",
+					WaitForDeploy: tc.waitForDeploy,
+				},
+			}
+
+			err = a.InstallApps(ctx, apps)
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+
+			var app v1alpha1.App
+			err = a.CtrlClient().Get(ctx, types.NamespacedName{Name: "test-app", Namespace: "giantswarm"}, &app)
+			if err != nil {
+				t.Fatalf("expected nil got %#v", err)
+			}
+
+			if app.Status.Release.Status != tc.expectedStatus {
+				t.Fatalf("expected status %#q got %#q", tc.expectedStatus, app.Status.Release.Status)
+			}
+			if app.Status.Version != "1.0.0" {
+				t.Fatalf("expected version %#q got %#q", "1.0.0", app.Status.Version)
+			}
+
+			calls := a.Calls()
+			if len(calls.InstallApps) != 1 {
+				t.Fatalf("expected 1 InstallApps call got %d", len(calls.InstallApps))
+			}
+		})
+	}
+}
+
+func Test_UpgradeApp(t *testing.T) {
+	ctx := context.Background()
+
+	a, err := New(Config{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	// The first script is consumed by the current app, the second one by
+	// the desired app.
+	a.ScriptApp("test-app", Transition{Status: StatusDeployed})
+	a.ScriptApp("test-app", Transition{Status: StatusFailed, Reason: "upgrade failed"})
+
+	current := apptest.App{
+		CatalogName: "default",
+		Name:        "test-app",
+		Namespace:   "giantswarm",
+		Version:     "1.0.0",
+	}
+	desired := current
+	desired.CatalogName = "default-test"
+	desired.Version = ""
+	desired.SHA = "ad12c88111d7513114a1257994634e2ae81115a2"
+
+	err = a.UpgradeApp(ctx, current, desired)
+	if !apptest.IsAppFailed(err) {
+		t.Fatalf("expected app failed error got %#v", err)
+	}
+
+	var appErr *apptest.AppError
+	if !errors.As(err, &appErr) || appErr.Reason != "upgrade failed" {
+		t.Fatalf("expected app error with reason %#q got %#v", "upgrade failed", err)
+	}
+
+	var app v1alpha1.App
+	err = a.CtrlClient().Get(ctx, types.NamespacedName{Name: "test-app", Namespace: "giantswarm"}, &app)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	expectedVersion := "0.0.0-ad12c88111d7513114a1257994634e2ae81115a2"
+	if app.Spec.Version != expectedVersion {
+		t.Fatalf("expected version %#q got %#q", expectedVersion, app.Spec.Version)
+	}
+
+	calls := a.Calls()
+	if len(calls.UpgradeApp) != 1 {
+		t.Fatalf("expected 1 UpgradeApp call got %d", len(calls.UpgradeApp))
+	}
+}
+
+func Test_RollbackApp(t *testing.T) {
+	ctx := context.Background()
+
+	a, err := New(Config{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	// Install and upgrade succeed, the rollback fails.
+	a.ScriptApp("test-app", Transition{Status: StatusDeployed})
+	a.ScriptApp("test-app", Transition{Status: StatusDeployed})
+	a.ScriptApp("test-app", Transition{Status: StatusFailed, Reason: "rollback failed"})
+
+	current := apptest.App{
+		CatalogName: "default",
+		Name:        "test-app",
+		Namespace:   "giantswarm",
+		Version:     "1.0.0",
+	}
+	desired := current
+	desired.Version = "1.1.0"
+
+	err = a.RollbackApp(ctx, current, desired)
+	if !apptest.IsAppFailed(err) {
+		t.Fatalf("expected app failed error got %#v", err)
+	}
+	if !strings.Contains(err.Error(), "step 3 of 3") {
+		t.Fatalf("expected error to name step 3 got %#q", err.Error())
+	}
+
+	var app v1alpha1.App
+	err = a.CtrlClient().Get(ctx, types.NamespacedName{Name: "test-app", Namespace: "giantswarm"}, &app)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	if app.Spec.Version != "1.0.0" {
+		t.Fatalf("expected version %#q got %#q", "1.0.0", app.Spec.Version)
+	}
+}
+
+func Test_UpgradePath_invalidConfig(t *testing.T) {
+	a, err := New(Config{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	err = a.UpgradePath(context.Background(), []apptest.App{{Name: "test-app", Version: "1.0.0"}})
+	if !apptest.IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error got %#v", err)
+	}
+}
+
+func Test_UninstallApp(t *testing.T) {
+	ctx := context.Background()
+
+	a, err := New(Config{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	app := apptest.App{
+		CatalogName: "default",
+		Name:        "test-app",
+		Namespace:   "giantswarm",
+		Version:     "1.0.0",
+	}
+
+	err = a.InstallApps(ctx, []apptest.App{app})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	err = a.UninstallApp(ctx, app)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	err = a.CtrlClient().Get(ctx, types.NamespacedName{Name: "test-app", Namespace: "giantswarm"}, &v1alpha1.App{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected not found error got %#v", err)
+	}
+
+	if len(a.Calls().UninstallApp) != 1 {
+		t.Fatalf("expected 1 uninstall call got %d", len(a.Calls().UninstallApp))
+	}
+}
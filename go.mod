@@ -3,6 +3,8 @@ module github.com/giantswarm/apptest
 go 1.16
 
 require (
+	github.com/Masterminds/semver/v3 v3.1.1
+	github.com/cenkalti/backoff v2.2.1+incompatible
 	github.com/giantswarm/apiextensions/v3 v3.32.0
 	github.com/giantswarm/app/v5 v5.2.3
 	github.com/giantswarm/appcatalog v0.6.0
@@ -14,6 +16,7 @@ require (
 	k8s.io/apimachinery v0.20.10
 	k8s.io/client-go v0.20.10
 	sigs.k8s.io/controller-runtime v0.6.5
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
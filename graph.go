@@ -0,0 +1,111 @@
+package apptest
+
+import (
+	"strings"
+
+	"github.com/giantswarm/microerror"
+)
+
+// dependencyGraph holds for every app the indexes of the apps it depends on.
+// Apps are referenced by index so several apps may share the same name, e.g.
+// when installing an app twice with different app CR names.
+type dependencyGraph [][]int
+
+// newDependencyGraph resolves the DependsOn names of the given apps and
+// returns an error if a dependency is unknown or the dependencies form a
+// cycle.
+func newDependencyGraph(apps []App) (dependencyGraph, error) {
+	indexes := map[string][]int{}
+	for i, app := range apps {
+		indexes[app.Name] = append(indexes[app.Name], i)
+	}
+
+	graph := make(dependencyGraph, len(apps))
+	for i, app := range apps {
+		for _, name := range app.DependsOn {
+			deps, ok := indexes[name]
+			if !ok {
+				return nil, microerror.Maskf(invalidConfigError, "app %#q depends on %#q which is not installed", app.Name, name)
+			}
+
+			graph[i] = append(graph[i], deps...)
+		}
+	}
+
+	cycle := graph.findCycle()
+	if cycle != nil {
+		var names []string
+		for _, i := range cycle {
+			names = append(names, apps[i].Name)
+		}
+
+		return nil, microerror.Maskf(invalidConfigError, "app dependencies form a cycle %s", strings.Join(names, " -> "))
+	}
+
+	return graph, nil
+}
+
+// dependencies returns for every app whether other apps depend on it.
+func (g dependencyGraph) dependencies() []bool {
+	dependencies := make([]bool, len(g))
+	for _, deps := range g {
+		for _, dep := range deps {
+			dependencies[dep] = true
+		}
+	}
+
+	return dependencies
+}
+
+// findCycle returns the indexes of the apps forming a dependency cycle,
+// starting and ending with the same app, or nil if there is none.
+func (g dependencyGraph) findCycle() []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(g))
+	var path []int
+
+	var visit func(i int) []int
+	visit = func(i int) []int {
+		state[i] = visiting
+		path = append(path, i)
+
+		for _, dep := range g[i] {
+			switch state[dep] {
+			case visiting:
+				for j, p := range path {
+					if p == dep {
+						return append(append([]int{}, path[j:]...), dep)
+					}
+				}
+			case unvisited:
+				cycle := visit(dep)
+				if cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[i] = visited
+
+		return nil
+	}
+
+	for i := range g {
+		if state[i] != unvisited {
+			continue
+		}
+
+		cycle := visit(i)
+		if cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
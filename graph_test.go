@@ -0,0 +1,97 @@
+package apptest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_newDependencyGraph(t *testing.T) {
+	testCases := []struct {
+		name          string
+		apps          []App
+		expectedGraph dependencyGraph
+		errorMatcher  func(error) bool
+	}{
+		{
+			name: "case 0: apps without dependencies",
+			apps: []App{
+				{Name: "a"},
+				{Name: "b"},
+			},
+			expectedGraph: dependencyGraph{nil, nil},
+		},
+		{
+			name: "case 1: chain of dependencies",
+			apps: []App{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"c"}},
+				{Name: "c"},
+			},
+			expectedGraph: dependencyGraph{{1}, {2}, nil},
+		},
+		{
+			name: "case 2: dependency on app installed twice",
+			apps: []App{
+				{Name: "a", AppCRName: "a-1"},
+				{Name: "a", AppCRName: "a-2"},
+				{Name: "b", DependsOn: []string{"a"}},
+			},
+			expectedGraph: dependencyGraph{nil, nil, {0, 1}},
+		},
+		{
+			name: "case 3: unknown dependency",
+			apps: []App{
+				{Name: "a", DependsOn: []string{"b"}},
+			},
+			errorMatcher: IsInvalidConfig,
+		},
+		{
+			name: "case 4: cycle",
+			apps: []App{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"c"}},
+				{Name: "c", DependsOn: []string{"a"}},
+			},
+			errorMatcher: IsInvalidConfig,
+		},
+		{
+			name: "case 5: app depending on itself",
+			apps: []App{
+				{Name: "a", DependsOn: []string{"a"}},
+			},
+			errorMatcher: IsInvalidConfig,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			graph, err := newDependencyGraph(tc.apps)
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+
+			if !reflect.DeepEqual(graph, tc.expectedGraph) {
+				t.Fatalf("graph == %v, want %v", graph, tc.expectedGraph)
+			}
+		})
+	}
+}
+
+func Test_findCycle(t *testing.T) {
+	graph := dependencyGraph{{1}, {2}, {1}}
+
+	cycle := graph.findCycle()
+
+	expected := []int{1, 2, 1}
+	if !reflect.DeepEqual(cycle, expected) {
+		t.Fatalf("cycle == %v, want %v", cycle, expected)
+	}
+}
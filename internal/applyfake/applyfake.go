@@ -0,0 +1,548 @@
+// Package applyfake emulates server-side apply for fake clients. The fields
+// owned by every field manager are tracked in the managed fields of objects
+// and applying fields owned by other field managers with other values
+// conflicts unless the apply is forced, like it does with the API server.
+package applyfake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+const (
+	// BeforeFirstApplyManager owns the fields of objects which were written
+	// without server-side apply.
+	BeforeFirstApplyManager = "before-first-apply"
+)
+
+// ignoredFields are not owned by field managers.
+var ignoredFields = [][]string{
+	{"apiVersion"},
+	{"kind"},
+	{"status"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "deletionGracePeriodSeconds"},
+	{"metadata", "deletionTimestamp"},
+	{"metadata", "generation"},
+	{"metadata", "managedFields"},
+	{"metadata", "name"},
+	{"metadata", "namespace"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "selfLink"},
+	{"metadata", "uid"},
+}
+
+// NewClient wraps a fake controller-runtime client so it supports
+// server-side apply patches. Applied objects are stored as the types the
+// scheme registers for them.
+func NewClient(c client.Client, scheme *runtime.Scheme) client.Client {
+	return &applyClient{
+		Client: c,
+		scheme: scheme,
+	}
+}
+
+type applyClient struct {
+	client.Client
+	scheme *runtime.Scheme
+}
+
+func (c *applyClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	options := &client.PatchOptions{}
+	options.ApplyOptions(opts)
+
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return err
+	}
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return err
+	}
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	applied, err := toMap(data)
+	if err != nil {
+		return err
+	}
+
+	current, err := c.scheme.New(gvk)
+	if err != nil {
+		return err
+	}
+	var live map[string]interface{}
+	err = c.Client.Get(ctx, key, current)
+	if apierrors.IsNotFound(err) {
+		// Fall through.
+	} else if err != nil {
+		return err
+	} else {
+		live, err = objectToMap(current)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := Apply(live, applied, options.FieldManager, options.Force != nil && *options.Force)
+	if err != nil {
+		return err
+	}
+
+	stored, err := c.scheme.New(gvk)
+	if err != nil {
+		return err
+	}
+	err = mapToObject(result, stored)
+	if err != nil {
+		return err
+	}
+
+	if live == nil {
+		err = c.Client.Create(ctx, stored)
+	} else {
+		err = c.Client.Update(ctx, stored)
+	}
+	if err != nil {
+		return err
+	}
+
+	return copyObject(stored, obj)
+}
+
+// AddReactor makes the fake clientset support server-side apply patches.
+// Patch options aren't passed to the reactors of fake clientsets, so every
+// apply is made by manager and not forced.
+func AddReactor(clientset *k8sfake.Clientset, manager string) {
+	tracker := clientset.Tracker()
+
+	clientset.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch, ok := action.(k8stesting.PatchAction)
+		if !ok || patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+
+		applied, err := toMap(patch.GetPatch())
+		if err != nil {
+			return true, nil, err
+		}
+
+		var live map[string]interface{}
+		current, err := tracker.Get(patch.GetResource(), patch.GetNamespace(), patch.GetName())
+		if apierrors.IsNotFound(err) {
+			// Fall through.
+		} else if err != nil {
+			return true, nil, err
+		} else {
+			live, err = objectToMap(current)
+			if err != nil {
+				return true, nil, err
+			}
+		}
+
+		result, err := Apply(live, applied, manager, false)
+		if err != nil {
+			return true, nil, err
+		}
+
+		apiVersion, _ := result["apiVersion"].(string)
+		kind, _ := result["kind"].(string)
+		stored, err := clientgoscheme.Scheme.New(schema.FromAPIVersionAndKind(apiVersion, kind))
+		if err != nil {
+			return true, nil, err
+		}
+		err = mapToObject(result, stored)
+		if err != nil {
+			return true, nil, err
+		}
+
+		if live == nil {
+			err = tracker.Create(patch.GetResource(), stored, patch.GetNamespace())
+		} else {
+			err = tracker.Update(patch.GetResource(), stored, patch.GetNamespace())
+		}
+		if err != nil {
+			return true, nil, err
+		}
+
+		return true, stored, nil
+	})
+}
+
+// Apply applies the object applied by manager to the live object, which is
+// nil when it doesn't exist yet, and returns the resulting object. Fields
+// owned by other managers with other values conflict unless force is set, in
+// which case manager takes them over. Fields manager applied before and no
+// longer applies are removed unless other managers own them. The status of
+// the live object is kept. Lists are treated as atomic values.
+func Apply(live, applied map[string]interface{}, manager string, force bool) (map[string]interface{}, error) {
+	for _, f := range []string{"apiVersion", "kind"} {
+		if s, _ := applied[f].(string); s == "" {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("%s must be set in applied object", f))
+		}
+	}
+	if s, _ := get(applied, []string{"metadata", "name"}); s == nil || s == "" {
+		return nil, apierrors.NewBadRequest("metadata.name must be set in applied object")
+	}
+	if manager == "" {
+		return nil, apierrors.NewBadRequest("field manager must be set for apply patches")
+	}
+
+	appliedFields := fields(applied)
+
+	var managers []*managerFields
+	if live != nil {
+		var err error
+		managers, err = decodeManagedFields(live)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var causes []metav1.StatusCause
+	for _, m := range managers {
+		if m.name == manager {
+			continue
+		}
+
+		for _, k := range sortedKeys(m.fields) {
+			p, ok := appliedFields[k]
+			if !ok {
+				continue
+			}
+			liveValue, _ := get(live, p)
+			appliedValue, _ := get(applied, p)
+			if reflect.DeepEqual(liveValue, appliedValue) {
+				continue
+			}
+
+			if force {
+				delete(m.fields, k)
+				continue
+			}
+
+			causes = append(causes, metav1.StatusCause{
+				Type:    metav1.CauseTypeFieldManagerConflict,
+				Message: fmt.Sprintf("conflict with %q", m.name),
+				Field:   "." + strings.Join(p, "."),
+			})
+		}
+	}
+	if len(causes) > 0 {
+		return nil, apierrors.NewApplyConflict(causes, fmt.Sprintf("Apply failed with %d conflicts", len(causes)))
+	}
+
+	result := map[string]interface{}{}
+	if live != nil {
+		result = runtime.DeepCopyJSON(live)
+	} else {
+		metadata, _ := applied["metadata"].(map[string]interface{})
+		result["metadata"] = map[string]interface{}{
+			"name": metadata["name"],
+		}
+		if metadata["namespace"] != nil {
+			set(result, []string{"metadata", "namespace"}, metadata["namespace"])
+		}
+	}
+	result["apiVersion"] = applied["apiVersion"]
+	result["kind"] = applied["kind"]
+
+	var own *managerFields
+	for _, m := range managers {
+		if m.name == manager {
+			own = m
+		}
+	}
+	if own == nil {
+		own = &managerFields{name: manager}
+		managers = append(managers, own)
+	}
+
+	for _, k := range sortedKeys(own.fields) {
+		if _, ok := appliedFields[k]; ok || ownedByOthers(managers, manager, k) {
+			continue
+		}
+		remove(result, own.fields[k])
+	}
+	for _, k := range sortedKeys(appliedFields) {
+		p := appliedFields[k]
+		v, _ := get(applied, p)
+		set(result, p, runtime.DeepCopyJSONValue(v))
+	}
+
+	own.fields = appliedFields
+	own.operation = string(metav1.ManagedFieldsOperationApply)
+	own.apiVersion, _ = applied["apiVersion"].(string)
+
+	var entries []interface{}
+	for _, m := range managers {
+		if len(m.fields) == 0 {
+			continue
+		}
+		entries = append(entries, m.encode())
+	}
+	set(result, []string{"metadata", "managedFields"}, entries)
+
+	return result, nil
+}
+
+// managerFields are the fields owned by a field manager.
+type managerFields struct {
+	name       string
+	operation  string
+	apiVersion string
+	fields     map[string][]string
+}
+
+func (m *managerFields) encode() map[string]interface{} {
+	fieldsV1 := map[string]interface{}{}
+	for _, p := range m.fields {
+		node := fieldsV1
+		for i, k := range p {
+			child, ok := node["f:"+k].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node["f:"+k] = child
+			}
+			if i < len(p)-1 {
+				node = child
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"apiVersion": m.apiVersion,
+		"fieldsType": "FieldsV1",
+		"fieldsV1":   fieldsV1,
+		"manager":    m.name,
+		"operation":  m.operation,
+	}
+}
+
+// decodeManagedFields returns the fields owned by every field manager of the
+// live object. Objects without managed fields were written without
+// server-side apply, their fields are owned by BeforeFirstApplyManager.
+func decodeManagedFields(live map[string]interface{}) ([]*managerFields, error) {
+	entries, _ := get(live, []string{"metadata", "managedFields"})
+	list, _ := entries.([]interface{})
+	if len(list) == 0 {
+		apiVersion, _ := live["apiVersion"].(string)
+		m := &managerFields{
+			name:       BeforeFirstApplyManager,
+			operation:  string(metav1.ManagedFieldsOperationUpdate),
+			apiVersion: apiVersion,
+			fields:     fields(live),
+		}
+
+		return []*managerFields{m}, nil
+	}
+
+	var managers []*managerFields
+	for _, e := range list {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid managed fields entry %#v", e)
+		}
+
+		m := &managerFields{
+			fields: map[string][]string{},
+		}
+		m.name, _ = entry["manager"].(string)
+		m.operation, _ = entry["operation"].(string)
+		m.apiVersion, _ = entry["apiVersion"].(string)
+
+		fieldsV1, _ := entry["fieldsV1"].(map[string]interface{})
+		decodeFieldsV1(fieldsV1, nil, m.fields)
+
+		managers = append(managers, m)
+	}
+
+	return managers, nil
+}
+
+func decodeFieldsV1(node map[string]interface{}, prefix []string, out map[string][]string) {
+	for k, v := range node {
+		if !strings.HasPrefix(k, "f:") {
+			continue
+		}
+
+		p := append(append([]string{}, prefix...), strings.TrimPrefix(k, "f:"))
+		child, _ := v.(map[string]interface{})
+		if len(child) == 0 {
+			out[key(p)] = p
+			continue
+		}
+
+		decodeFieldsV1(child, p, out)
+	}
+}
+
+func ownedByOthers(managers []*managerFields, manager string, k string) bool {
+	for _, m := range managers {
+		if m.name == manager {
+			continue
+		}
+		if _, ok := m.fields[k]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fields returns the paths of the leaf fields of the object which are owned
+// by field managers. Empty maps and lists are leaves.
+func fields(obj map[string]interface{}) map[string][]string {
+	out := map[string][]string{}
+	leaves(obj, nil, out)
+
+	for _, p := range ignoredFields {
+		for k, q := range out {
+			if len(q) >= len(p) && reflect.DeepEqual(q[:len(p)], p) {
+				delete(out, k)
+			}
+		}
+	}
+
+	return out
+}
+
+func leaves(node map[string]interface{}, prefix []string, out map[string][]string) {
+	for k, v := range node {
+		p := append(append([]string{}, prefix...), k)
+
+		child, ok := v.(map[string]interface{})
+		if !ok || len(child) == 0 {
+			out[key(p)] = p
+			continue
+		}
+
+		leaves(child, p, out)
+	}
+}
+
+func key(p []string) string {
+	return strings.Join(p, "\x00")
+}
+
+func sortedKeys(m map[string][]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func get(obj map[string]interface{}, p []string) (interface{}, bool) {
+	var v interface{} = obj
+	for _, k := range p {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		v, ok = m[k]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func set(obj map[string]interface{}, p []string, v interface{}) {
+	m := obj
+	for _, k := range p[:len(p)-1] {
+		child, ok := m[k].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[k] = child
+		}
+		m = child
+	}
+
+	m[p[len(p)-1]] = v
+}
+
+// remove deletes the field and the maps which are empty without it.
+func remove(obj map[string]interface{}, p []string) {
+	m, ok := obj, true
+	if len(p) > 1 {
+		var v interface{}
+		v, ok = get(obj, p[:len(p)-1])
+		m, _ = v.(map[string]interface{})
+	}
+	if !ok || m == nil {
+		return
+	}
+
+	delete(m, p[len(p)-1])
+
+	if len(m) == 0 && len(p) > 1 {
+		remove(obj, p[:len(p)-1])
+	}
+}
+
+func toMap(data []byte) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+
+	return m, nil
+}
+
+func objectToMap(obj runtime.Object) (map[string]interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return toMap(data)
+}
+
+func mapToObject(m map[string]interface{}, obj runtime.Object) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, obj)
+}
+
+// copyObject copies the stored object into obj, which can be unstructured.
+func copyObject(stored, obj runtime.Object) error {
+	m, err := objectToMap(stored)
+	if err != nil {
+		return err
+	}
+
+	if u, ok := obj.(runtime.Unstructured); ok {
+		u.SetUnstructuredContent(m)
+		return nil
+	}
+
+	return mapToObject(m, obj)
+}
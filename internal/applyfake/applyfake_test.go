@@ -0,0 +1,83 @@
+package applyfake
+
+import (
+	"reflect"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func newConfigMap(data map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":      "test",
+			"namespace": "default",
+		},
+		"data": data,
+	}
+}
+
+func Test_Apply(t *testing.T) {
+	created, err := Apply(nil, newConfigMap(map[string]interface{}{"a": "1", "b": "1"}), "first", false)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	// Fields of other managers with the same value are shared.
+	shared, err := Apply(created, newConfigMap(map[string]interface{}{"a": "1"}), "second", false)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	// Fields of other managers with other values conflict.
+	_, err = Apply(shared, newConfigMap(map[string]interface{}{"a": "2"}), "second", false)
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("expected conflict error got %#v", err)
+	}
+
+	// Forced applies take them over.
+	forced, err := Apply(shared, newConfigMap(map[string]interface{}{"a": "2"}), "second", true)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if !reflect.DeepEqual(forced["data"], map[string]interface{}{"a": "2", "b": "1"}) {
+		t.Fatalf("expected data to be taken over got %#v", forced["data"])
+	}
+
+	// Fields no longer applied are removed.
+	removed, err := Apply(forced, newConfigMap(map[string]interface{}{"a": "2"}), "first", true)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if !reflect.DeepEqual(removed["data"], map[string]interface{}{"a": "2"}) {
+		t.Fatalf("expected field %#q to be removed got %#v", "b", removed["data"])
+	}
+}
+
+func Test_Apply_beforeFirstApply(t *testing.T) {
+	live := newConfigMap(map[string]interface{}{"a": "1"})
+	live["status"] = map[string]interface{}{"phase": "ready"}
+
+	// Objects written without server-side apply are owned by
+	// BeforeFirstApplyManager.
+	_, err := Apply(live, newConfigMap(map[string]interface{}{"a": "2"}), "first", false)
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("expected conflict error got %#v", err)
+	}
+
+	applied := newConfigMap(map[string]interface{}{"a": "2"})
+	applied["status"] = map[string]interface{}{"phase": "unknown"}
+
+	result, err := Apply(live, applied, "first", true)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if !reflect.DeepEqual(result["data"], map[string]interface{}{"a": "2"}) {
+		t.Fatalf("expected data to be applied got %#v", result["data"])
+	}
+	if !reflect.DeepEqual(result["status"], live["status"]) {
+		t.Fatalf("expected status to be kept got %#v", result["status"])
+	}
+}
@@ -0,0 +1,19 @@
+// Package chartvalues merges Helm chart values like app-operator does with
+// the catalog, cluster and user config of an app.
+package chartvalues
+
+// Merge merges src into dst. Nested maps are merged, any other value in src
+// overrides the one in dst.
+func Merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+
+		if srcIsMap && dstIsMap {
+			Merge(dstMap, srcMap)
+			continue
+		}
+
+		dst[k] = v
+	}
+}
@@ -0,0 +1,37 @@
+package chartvalues
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Merge(t *testing.T) {
+	dst := map[string]interface{}{
+		"image": map[string]interface{}{
+			"registry":   "quay.io",
+			"repository": "giantswarm/test-app",
+		},
+		"replicas": 1,
+	}
+	src := map[string]interface{}{
+		"image": map[string]interface{}{
+			"registry": "docker.io",
+		},
+		"replicas":  []interface{}{2},
+		"resources": "small",
+	}
+
+	Merge(dst, src)
+
+	expected := map[string]interface{}{
+		"image": map[string]interface{}{
+			"registry":   "docker.io",
+			"repository": "giantswarm/test-app",
+		},
+		"replicas":  []interface{}{2},
+		"resources": "small",
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Fatalf("expected %#v got %#v", expected, dst)
+	}
+}
@@ -0,0 +1,91 @@
+package apptest
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	kindApp        = "App"
+	kindAppCatalog = "AppCatalog"
+	kindCatalog    = "Catalog"
+	kindConfigMap  = "ConfigMap"
+	kindNamespace  = "Namespace"
+	kindSecret     = "Secret"
+)
+
+// inventoryObject identifies an object created by the app setup.
+type inventoryObject struct {
+	kind      string
+	name      string
+	namespace string
+
+	// app is set for app CRs so clean up can wait for the app's workloads
+	// to be deleted.
+	app *App
+	// shared is set for objects other app setups use too. They are not
+	// recorded when they are created so clean up leaves them behind.
+	shared bool
+}
+
+// String returns the kind and name of the object for logs and errors.
+func (o inventoryObject) String() string {
+	if o.namespace == "" {
+		return fmt.Sprintf("%s %#q", o.kind, o.name)
+	}
+
+	return fmt.Sprintf("%s '%s/%s'", o.kind, o.namespace, o.name)
+}
+
+// inventory records the objects created by the app setup in creation order
+// so they can be deleted in reverse order. Objects which already existed are
+// not recorded.
+type inventory struct {
+	mutex   sync.Mutex
+	objects []inventoryObject
+}
+
+func (i *inventory) add(obj inventoryObject) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for _, o := range i.objects {
+		if o.kind == obj.kind && o.name == obj.name && o.namespace == obj.namespace {
+			return
+		}
+	}
+
+	i.objects = append(i.objects, obj)
+}
+
+func (i *inventory) contains(kind, name, namespace string) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for _, o := range i.objects {
+		if o.kind == kind && o.name == name && o.namespace == namespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (i *inventory) list() []inventoryObject {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return append([]inventoryObject{}, i.objects...)
+}
+
+func (i *inventory) remove(obj inventoryObject) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for j, o := range i.objects {
+		if o.kind == obj.kind && o.name == obj.name && o.namespace == obj.namespace {
+			i.objects = append(i.objects[:j], i.objects[j+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package apptest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	ociScheme = "oci://"
+)
+
+var (
+	challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	nextLinkRegexp       = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+)
+
+// catalogType returns the storage type of the app's catalog. OCI catalogs are
+// detected by their oci:// URL when the type is not set.
+func catalogType(app App, catalogURL string) string {
+	if app.CatalogType != "" {
+		return app.CatalogType
+	}
+	if strings.HasPrefix(catalogURL, ociScheme) {
+		return CatalogTypeOCI
+	}
+
+	return CatalogTypeHelm
+}
+
+// listOCITags lists the tags of the chart repository in an OCI registry using
+// the distribution API, e.g. oci://ghcr.io/giantswarm/charts and chart
+// cert-manager-app list the tags of ghcr.io/giantswarm/charts/cert-manager-app.
+// Registries on localhost are accessed via plain HTTP. Helm replaces + with _
+// in tags so they are replaced back.
+func (a *AppSetup) listOCITags(ctx context.Context, catalogURL, chart string) ([]string, error) {
+	u, err := url.Parse(catalogURL)
+	if err != nil {
+		return nil, microerror.Maskf(invalidConfigError, "invalid OCI catalog URL %#q: %s", catalogURL, err)
+	}
+
+	scheme := "https"
+	if isLocalhost(u.Hostname()) {
+		scheme = "http"
+	}
+
+	repository := strings.Trim(strings.TrimSuffix(u.Path, "/")+"/"+chart, "/")
+	next := fmt.Sprintf("%s://%s/v2/%s/tags/list", scheme, u.Host, repository)
+
+	var token string
+	var tags []string
+	for next != "" {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+
+		var link string
+		link, token, err = a.getOCI(ctx, next, repository, token, &page)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		for _, t := range page.Tags {
+			tags = append(tags, strings.ReplaceAll(t, "_", "+"))
+		}
+
+		next = ""
+		if link != "" {
+			l, err := url.Parse(link)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+
+			base, _ := url.Parse(fmt.Sprintf("%s://%s/", scheme, u.Host))
+			next = base.ResolveReference(l).String()
+		}
+	}
+
+	return tags, nil
+}
+
+// getOCI gets the JSON document from the registry. Anonymous bearer tokens
+// are requested when the registry asks for them. It returns the next page
+// link and the token for following requests.
+func (a *AppSetup) getOCI(ctx context.Context, u, repository, token string, v interface{}) (string, string, error) {
+	resp, err := a.doOCI(ctx, u, token)
+	if err != nil {
+		return "", "", microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized && token == "" {
+		token, err = a.ociToken(ctx, resp.Header.Get("WWW-Authenticate"), repository)
+		if err != nil {
+			return "", "", microerror.Mask(err)
+		}
+
+		return a.getOCI(ctx, u, repository, token, v)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", "", microerror.Maskf(notFoundError, "OCI repository %#q", repository)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", microerror.Maskf(executionFailedError, "getting %#q returned status %d", u, resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return "", "", microerror.Mask(err)
+	}
+
+	var link string
+	if matches := nextLinkRegexp.FindStringSubmatch(resp.Header.Get("Link")); matches != nil {
+		link = matches[1]
+	}
+
+	return link, token, nil
+}
+
+func (a *AppSetup) doOCI(ctx context.Context, u, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return resp, nil
+}
+
+// ociToken requests an anonymous pull token from the realm of the bearer
+// challenge.
+func (a *AppSetup) ociToken(ctx context.Context, challenge, repository string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", microerror.Maskf(executionFailedError, "unsupported registry authentication %#q", challenge)
+	}
+
+	params := map[string]string{}
+	for _, m := range challengeParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", microerror.Maskf(executionFailedError, "invalid registry authentication realm %#q", params["realm"])
+	}
+
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", repository)
+	}
+
+	q := realm.Query()
+	q.Set("scope", scope)
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	realm.RawQuery = q.Encode()
+
+	resp, err := a.doOCI(ctx, realm.String(), "")
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", microerror.Maskf(executionFailedError, "requesting registry token returned status %d", resp.StatusCode)
+	}
+
+	var t struct {
+		AccessToken string `json:"access_token"`
+		Token       string `json:"token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&t)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	if t.Token != "" {
+		return t.Token, nil
+	}
+
+	return t.AccessToken, nil
+}
+
+// latestTaggedVersion returns the highest semantic version among the tags
+// ending with the suffix. Tags which are no semantic versions are ignored.
+func latestTaggedVersion(tags []string, suffix string) (string, error) {
+	var latest *semver.Version
+	var latestTag string
+
+	for _, t := range tags {
+		if !strings.HasSuffix(t, suffix) {
+			continue
+		}
+
+		v, err := semver.NewVersion(t)
+		if err != nil {
+			continue
+		}
+
+		if latest == nil || v.GreaterThan(latest) {
+			latest = v
+			latestTag = t
+		}
+	}
+
+	if latest == nil {
+		return "", microerror.Maskf(notFoundError, "no version with suffix %#q", suffix)
+	}
+
+	return latestTag, nil
+}
+
+func isLocalhost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
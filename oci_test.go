@@ -0,0 +1,148 @@
+package apptest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newTestRegistry returns a registry stand-in serving the tags of
+// charts/test-app in two pages and requiring an anonymous bearer token.
+func newTestRegistry(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if !strings.HasPrefix(r.URL.Query().Get("scope"), "repository:charts/") {
+				http.Error(w, "invalid scope", http.StatusBadRequest)
+				return
+			}
+
+			fmt.Fprint(w, `{"token": "test-token"}`)
+		case r.Header.Get("Authorization") != "Bearer test-token":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/charts/test-app/tags/list" && r.URL.Query().Get("last") == "":
+			w.Header().Set("Link", `</v2/charts/test-app/tags/list?last=0.2.0>; rel="next"`)
+			fmt.Fprint(w, `{"name": "charts/test-app", "tags": ["0.1.0", "0.2.0"]}`)
+		case r.URL.Path == "/v2/charts/test-app/tags/list":
+			fmt.Fprint(w, `{"name": "charts/test-app", "tags": ["0.2.1-ad12c88111d7513114a1257994634e2ae81115a2", "1.0.0_build.1", "latest"]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return server
+}
+
+func Test_getLatestVersion_oci(t *testing.T) {
+	server := newTestRegistry(t)
+	defer server.Close()
+
+	catalogURL := "oci://" + strings.TrimPrefix(server.URL, "http://") + "/charts/"
+
+	testCases := []struct {
+		name            string
+		app             App
+		suffix          string
+		expectedVersion string
+		errorMatcher    func(error) bool
+	}{
+		{
+			name:            "case 0: latest version",
+			app:             App{CatalogName: "oci", CatalogURL: catalogURL, Name: "test-app"},
+			expectedVersion: "1.0.0+build.1",
+		},
+		{
+			name:            "case 1: test version of commit",
+			app:             App{CatalogName: "oci", CatalogURL: catalogURL, Name: "test-app"},
+			suffix:          "ad12c88111d7513114a1257994634e2ae81115a2",
+			expectedVersion: "0.2.1-ad12c88111d7513114a1257994634e2ae81115a2",
+		},
+		{
+			name:         "case 2: unknown commit",
+			app:          App{CatalogName: "oci", CatalogURL: catalogURL, Name: "test-app"},
+			suffix:       "0000000",
+			errorMatcher: IsVersionNotFound,
+		},
+		{
+			name:         "case 3: unknown chart",
+			app:          App{CatalogName: "oci", CatalogURL: catalogURL, Name: "other-app"},
+			errorMatcher: IsVersionNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestAppSetup(t, Config{})
+
+			version, err := a.getLatestVersion(context.Background(), tc.app, tc.suffix)
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+
+			if version != tc.expectedVersion {
+				t.Fatalf("version == %#q, want %#q", version, tc.expectedVersion)
+			}
+		})
+	}
+}
+
+func Test_InstallApps_oci(t *testing.T) {
+	ctx := context.Background()
+
+	server := newTestRegistry(t)
+	defer server.Close()
+
+	a := newTestAppSetup(t, Config{})
+
+	app := App{
+		CatalogName: "oci-test",
+		CatalogURL:  "oci://" + strings.TrimPrefix(server.URL, "http://") + "/charts/",
+		Name:        "test-app",
+		Namespace:   "test",
+		SHA:         "ad12c88111d7513114a1257994634e2ae81115a2",
+	}
+
+	err := a.InstallApps(ctx, []App{app})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	var catalog v1alpha1.Catalog
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "oci-test", Namespace: metav1.NamespaceDefault}, &catalog)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if catalog.Spec.Storage.Type != CatalogTypeOCI {
+		t.Fatalf("expected storage type %#q got %#q", CatalogTypeOCI, catalog.Spec.Storage.Type)
+	}
+
+	var appCR v1alpha1.App
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: defaultNamespace}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	expectedVersion := "0.2.1-ad12c88111d7513114a1257994634e2ae81115a2"
+	if appCR.Spec.Version != expectedVersion {
+		t.Fatalf("expected version %#q got %#q", expectedVersion, appCR.Spec.Version)
+	}
+}
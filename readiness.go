@@ -0,0 +1,281 @@
+package apptest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/backoff"
+	"github.com/giantswarm/microerror"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Helm sets these annotations on every resource of a release.
+	releaseNameAnnotation      = "meta.helm.sh/release-name"
+	releaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+// failedWaitingReasons are the reasons of waiting containers which won't
+// start without intervention.
+var failedWaitingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+}
+
+// workload is a Deployment, StatefulSet, DaemonSet or Job of a Helm release.
+type workload struct {
+	kind     string
+	name     string
+	selector *metav1.LabelSelector
+	// notReady describes why the workload is not ready. It is empty when
+	// the workload is ready.
+	notReady string
+	// failed is set for failed jobs.
+	failed bool
+}
+
+// waitForReadyWorkloads waits until every workload of the app's Helm release
+// is rolled out and ready. It fails as soon as a pod of a workload can't start
+// or a job failed.
+func (a *AppSetup) waitForReadyWorkloads(ctx context.Context, app App) error {
+	k8sClient, _, err := a.appClients(app)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	release := appCRName(app)
+
+	a.logger.Debugf(ctx, "waiting for workloads of release %#q in namespace %#q to be ready", release, app.Namespace)
+
+	o := func() error {
+		workloads, err := listWorkloads(ctx, k8sClient, app.Namespace, release)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		var notReady []string
+		for _, w := range workloads {
+			if w.failed {
+				return backoff.Permanent(newAppError(workloadFailedError, app.Name, "", w.notReady, "%s %#q failed: %s", w.kind, w.name, w.notReady))
+			}
+			if w.notReady == "" {
+				continue
+			}
+
+			reason, err := failedPodReason(ctx, k8sClient, app.Namespace, w.selector)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+			if reason != "" {
+				return backoff.Permanent(newAppError(workloadFailedError, app.Name, "", reason, "%s %#q can't start: %s", w.kind, w.name, reason))
+			}
+
+			notReady = append(notReady, fmt.Sprintf("%s %#q: %s", w.kind, w.name, w.notReady))
+		}
+
+		if len(notReady) > 0 {
+			return microerror.Maskf(executionFailedError, "%d workloads are not ready: %s", len(notReady), strings.Join(notReady, "; "))
+		}
+
+		return nil
+	}
+
+	n := func(err error, t time.Duration) {
+		a.logger.Debugf(ctx, "waiting for workloads of release %#q: %s", release, err)
+	}
+
+	err = retryNotify(ctx, o, backoff.NewConstant(a.waitTimeout(app), a.waitInterval(app)), n)
+	if IsWorkloadFailed(err) {
+		a.dumpDiagnostics(ctx, app)
+		return microerror.Mask(err)
+	} else if err != nil {
+		a.dumpDiagnostics(ctx, app)
+		return microerror.Mask(newAppError(waitTimeoutError, app.Name, "", "", "workloads of release %#q are not ready: %s", release, err))
+	}
+
+	a.logger.Debugf(ctx, "waited for workloads of release %#q in namespace %#q to be ready", release, app.Namespace)
+
+	return nil
+}
+
+// listWorkloads returns the workloads of the Helm release with their
+// readiness.
+func listWorkloads(ctx context.Context, k8sClient kubernetes.Interface, namespace, release string) ([]workload, error) {
+	var workloads []workload
+
+	deployments, err := k8sClient.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	for _, d := range deployments.Items {
+		if !inRelease(&d, namespace, release) {
+			continue
+		}
+
+		workloads = append(workloads, workload{kind: "Deployment", name: d.Name, selector: d.Spec.Selector, notReady: deploymentNotReady(d)})
+	}
+
+	statefulSets, err := k8sClient.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	for _, s := range statefulSets.Items {
+		if !inRelease(&s, namespace, release) {
+			continue
+		}
+
+		workloads = append(workloads, workload{kind: "StatefulSet", name: s.Name, selector: s.Spec.Selector, notReady: statefulSetNotReady(s)})
+	}
+
+	daemonSets, err := k8sClient.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	for _, d := range daemonSets.Items {
+		if !inRelease(&d, namespace, release) {
+			continue
+		}
+
+		workloads = append(workloads, workload{kind: "DaemonSet", name: d.Name, selector: d.Spec.Selector, notReady: daemonSetNotReady(d)})
+	}
+
+	jobs, err := k8sClient.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	for _, j := range jobs.Items {
+		if !inRelease(&j, namespace, release) {
+			continue
+		}
+
+		notReady, failed := jobNotReady(j)
+		workloads = append(workloads, workload{kind: "Job", name: j.Name, selector: j.Spec.Selector, notReady: notReady, failed: failed})
+	}
+
+	return workloads, nil
+}
+
+// inRelease returns whether the object belongs to the Helm release.
+func inRelease(obj metav1.Object, namespace, release string) bool {
+	return obj.GetAnnotations()[releaseNameAnnotation] == release && obj.GetAnnotations()[releaseNamespaceAnnotation] == namespace
+}
+
+func deploymentNotReady(d appsv1.Deployment) string {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+
+	switch {
+	case d.Status.ObservedGeneration < d.Generation:
+		return "rollout not observed yet"
+	case d.Status.UpdatedReplicas < replicas:
+		return fmt.Sprintf("%d of %d replicas updated", d.Status.UpdatedReplicas, replicas)
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return fmt.Sprintf("%d old replicas pending termination", d.Status.Replicas-d.Status.UpdatedReplicas)
+	case d.Status.AvailableReplicas < replicas:
+		return fmt.Sprintf("%d of %d replicas available", d.Status.AvailableReplicas, replicas)
+	}
+
+	return ""
+}
+
+func statefulSetNotReady(s appsv1.StatefulSet) string {
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+
+	switch {
+	case s.Status.ObservedGeneration < s.Generation:
+		return "rollout not observed yet"
+	case s.Spec.UpdateStrategy.Type != appsv1.OnDeleteStatefulSetStrategyType && s.Status.UpdateRevision != s.Status.CurrentRevision:
+		return fmt.Sprintf("%d of %d replicas updated", s.Status.UpdatedReplicas, replicas)
+	case s.Status.ReadyReplicas < replicas:
+		return fmt.Sprintf("%d of %d replicas ready", s.Status.ReadyReplicas, replicas)
+	}
+
+	return ""
+}
+
+func daemonSetNotReady(d appsv1.DaemonSet) string {
+	switch {
+	case d.Status.ObservedGeneration < d.Generation:
+		return "rollout not observed yet"
+	case d.Status.UpdatedNumberScheduled < d.Status.DesiredNumberScheduled:
+		return fmt.Sprintf("%d of %d pods updated", d.Status.UpdatedNumberScheduled, d.Status.DesiredNumberScheduled)
+	case d.Status.NumberAvailable < d.Status.DesiredNumberScheduled:
+		return fmt.Sprintf("%d of %d pods available", d.Status.NumberAvailable, d.Status.DesiredNumberScheduled)
+	}
+
+	return ""
+}
+
+// jobNotReady returns why the job is not complete and whether it failed.
+func jobNotReady(j batchv1.Job) (string, bool) {
+	for _, c := range j.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		switch c.Type {
+		case batchv1.JobComplete:
+			return "", false
+		case batchv1.JobFailed:
+			return fmt.Sprintf("%s: %s", c.Reason, c.Message), true
+		}
+	}
+
+	completions := int32(1)
+	if j.Spec.Completions != nil {
+		completions = *j.Spec.Completions
+	}
+
+	return fmt.Sprintf("%d of %d completions", j.Status.Succeeded, completions), false
+}
+
+// failedPodReason returns the reason of the first container of the selected
+// pods which won't start without intervention, e.g. ImagePullBackOff.
+func failedPodReason(ctx context.Context, k8sClient kubernetes.Interface, namespace string, selector *metav1.LabelSelector) (string, error) {
+	if selector == nil {
+		return "", nil
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	pods, err := k8sClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: s.String()})
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Name < pods.Items[j].Name
+	})
+
+	for _, p := range pods.Items {
+		statuses := append(append([]corev1.ContainerStatus{}, p.Status.InitContainerStatuses...), p.Status.ContainerStatuses...)
+		for _, c := range statuses {
+			if c.State.Waiting == nil || !failedWaitingReasons[c.State.Waiting.Reason] {
+				continue
+			}
+
+			return fmt.Sprintf("pod %#q container %#q is waiting with reason %#q: %s", p.Name, c.Name, c.State.Waiting.Reason, c.State.Waiting.Message), nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,182 @@
+package apptest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_waitForReadyWorkloads(t *testing.T) {
+	releaseAnnotations := map[string]string{
+		releaseNameAnnotation:      "test-app",
+		releaseNamespaceAnnotation: "test",
+	}
+	selector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": "test-app"},
+	}
+
+	newDeployment := func(available int32) *appsv1.Deployment {
+		replicas := int32(2)
+
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: releaseAnnotations,
+				Name:        "test-app",
+				Namespace:   "test",
+			},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: selector,
+			},
+			Status: appsv1.DeploymentStatus{
+				AvailableReplicas: available,
+				Replicas:          replicas,
+				UpdatedReplicas:   replicas,
+			},
+		}
+	}
+
+	testCases := []struct {
+		name         string
+		objs         []runtime.Object
+		errorMatcher func(error) bool
+		reason       string
+	}{
+		{
+			name: "case 0: ready deployment and completed job",
+			objs: []runtime.Object{
+				newDeployment(2),
+				&batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: releaseAnnotations,
+						Name:        "test-app-migrate",
+						Namespace:   "test",
+					},
+					Status: batchv1.JobStatus{
+						Conditions: []batchv1.JobCondition{
+							{
+								Status: corev1.ConditionTrue,
+								Type:   batchv1.JobComplete,
+							},
+						},
+						Succeeded: 1,
+					},
+				},
+			},
+		},
+		{
+			name: "case 1: workloads of other releases are ignored",
+			objs: []runtime.Object{
+				&appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "other-app",
+						Namespace: "test",
+					},
+				},
+			},
+		},
+		{
+			name: "case 2: pod in image pull back off fails early",
+			objs: []runtime.Object{
+				newDeployment(0),
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels:    map[string]string{"app": "test-app"},
+						Name:      "test-app-1",
+						Namespace: "test",
+					},
+					Status: corev1.PodStatus{
+						ContainerStatuses: []corev1.ContainerStatus{
+							{
+								Name: "test-app",
+								State: corev1.ContainerState{
+									Waiting: &corev1.ContainerStateWaiting{
+										Message: "Back-off pulling image",
+										Reason:  "ImagePullBackOff",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			errorMatcher: IsWorkloadFailed,
+			reason:       "pod `test-app-1` container `test-app` is waiting with reason `ImagePullBackOff`: Back-off pulling image",
+		},
+		{
+			name: "case 3: failed job fails early",
+			objs: []runtime.Object{
+				&batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: releaseAnnotations,
+						Name:        "test-app-migrate",
+						Namespace:   "test",
+					},
+					Status: batchv1.JobStatus{
+						Conditions: []batchv1.JobCondition{
+							{
+								Message: "Job has reached the specified backoff limit",
+								Reason:  "BackoffLimitExceeded",
+								Status:  corev1.ConditionTrue,
+								Type:    batchv1.JobFailed,
+							},
+						},
+					},
+				},
+			},
+			errorMatcher: IsWorkloadFailed,
+			reason:       "BackoffLimitExceeded: Job has reached the specified backoff limit",
+		},
+		{
+			name: "case 4: unavailable deployment times out",
+			objs: []runtime.Object{
+				newDeployment(1),
+			},
+			errorMatcher: IsWaitTimeout,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			a := newTestAppSetup(t, Config{})
+			a.k8sClient = k8sfake.NewSimpleClientset(tc.objs...)
+
+			app := App{
+				Name:         "test-app",
+				Namespace:    "test",
+				WaitInterval: 10 * time.Millisecond,
+				WaitTimeout:  100 * time.Millisecond,
+			}
+
+			err := a.waitForReadyWorkloads(ctx, app)
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+
+			if tc.reason != "" {
+				var appErr *AppError
+				if !errors.As(err, &appErr) || appErr.Reason != tc.reason {
+					t.Fatalf("expected app error with reason %#q got %#v", tc.reason, appErr)
+				}
+			}
+		})
+	}
+}
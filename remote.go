@@ -0,0 +1,165 @@
+package apptest
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/giantswarm/microerror"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clientcmdlatest "k8s.io/client-go/tools/clientcmd/api/latest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+)
+
+// WorkloadClients are the clients of the remote cluster an app is installed
+// in.
+type WorkloadClients struct {
+	CtrlClient client.Client
+	K8sClient  kubernetes.Interface
+	RESTConfig *rest.Config
+}
+
+// isRemote returns whether the app is installed in a remote cluster.
+func isRemote(app App) bool {
+	return app.KubeConfig != "" || app.KubeConfigPath != ""
+}
+
+// remoteKubeConfig returns the kubeconfig of the remote cluster of the app
+// reduced to the selected context with files inlined, so app-operator can
+// use it from a secret, and the name of the context. Kubeconfig content
+// without context name is used as is with the context named after the
+// secret.
+func remoteKubeConfig(app App) ([]byte, string, error) {
+	if app.KubeConfig != "" && app.KubeConfigPath != "" {
+		return nil, "", microerror.Maskf(invalidConfigError, "%T.KubeConfig and %T.KubeConfigPath of app %#q must not be set at the same time", app, app, app.Name)
+	}
+
+	if app.KubeConfig != "" && app.KubeConfigContext == "" {
+		return []byte(app.KubeConfig), kubeConfigSecretName(app), nil
+	}
+
+	var err error
+	var kubeConfig *clientcmdapi.Config
+	if app.KubeConfigPath != "" {
+		kubeConfig, err = clientcmd.LoadFromFile(app.KubeConfigPath)
+		if err != nil {
+			return nil, "", microerror.Maskf(invalidConfigError, "kubeconfig %#q of app %#q can't be loaded: %s", app.KubeConfigPath, app.Name, err)
+		}
+	} else {
+		kubeConfig, err = clientcmd.Load([]byte(app.KubeConfig))
+		if err != nil {
+			return nil, "", microerror.Maskf(invalidConfigError, "kubeconfig of app %#q can't be loaded: %s", app.Name, err)
+		}
+	}
+
+	contextName := app.KubeConfigContext
+	if contextName == "" {
+		contextName = kubeConfig.CurrentContext
+	}
+	if _, ok := kubeConfig.Contexts[contextName]; !ok {
+		return nil, "", microerror.Maskf(invalidConfigError, "kubeconfig of app %#q has no context %#q", app.Name, contextName)
+	}
+
+	kubeConfig.CurrentContext = contextName
+
+	err = clientcmdapi.MinifyConfig(kubeConfig)
+	if err != nil {
+		return nil, "", microerror.Mask(err)
+	}
+	err = clientcmdapi.FlattenConfig(kubeConfig)
+	if err != nil {
+		return nil, "", microerror.Mask(err)
+	}
+
+	v1KubeConfig, err := clientcmdlatest.Scheme.ConvertToVersion(kubeConfig, clientcmdlatest.ExternalVersion)
+	if err != nil {
+		return nil, "", microerror.Mask(err)
+	}
+
+	bytes, err := yaml.Marshal(v1KubeConfig)
+	if err != nil {
+		return nil, "", microerror.Mask(err)
+	}
+
+	return bytes, contextName, nil
+}
+
+// workloadClientsKey identifies the remote cluster of a kubeconfig context.
+func workloadClientsKey(kubeConfig []byte, contextName string) string {
+	return fmt.Sprintf("%s/%x", contextName, sha256.Sum256(kubeConfig))
+}
+
+// WorkloadClients returns the clients of the remote cluster the app is
+// installed in, e.g. to assert on resources deployed there. The clients are
+// created once per cluster.
+func (a *AppSetup) WorkloadClients(app App) (*WorkloadClients, error) {
+	if !isRemote(app) {
+		return nil, microerror.Maskf(invalidConfigError, "app %#q is not installed in a remote cluster", app.Name)
+	}
+
+	kubeConfig, contextName, err := remoteKubeConfig(app)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	key := workloadClientsKey(kubeConfig, contextName)
+
+	a.workloadClientsMutex.Lock()
+	defer a.workloadClientsMutex.Unlock()
+
+	if c, ok := a.workloadClients[key]; ok {
+		return c, nil
+	}
+
+	config, err := clientcmd.Load(kubeConfig)
+	if err != nil {
+		return nil, microerror.Maskf(invalidConfigError, "kubeconfig of app %#q can't be loaded: %s", app.Name, err)
+	}
+
+	restConfig, err := clientcmd.NewNonInteractiveClientConfig(*config, contextName, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	mapper, err := apiutil.NewDynamicRESTMapper(rest.CopyConfig(restConfig))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	ctrlClient, err := client.New(rest.CopyConfig(restConfig), client.Options{Scheme: a.scheme, Mapper: mapper})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	k8sClient, err := kubernetes.NewForConfig(rest.CopyConfig(restConfig))
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	c := &WorkloadClients{
+		CtrlClient: ctrlClient,
+		K8sClient:  k8sClient,
+		RESTConfig: restConfig,
+	}
+	a.workloadClients[key] = c
+
+	return c, nil
+}
+
+// appClients returns the clients of the cluster the app is installed in.
+func (a *AppSetup) appClients(app App) (kubernetes.Interface, client.Client, error) {
+	if !isRemote(app) {
+		return a.k8sClient, a.ctrlClient, nil
+	}
+
+	c, err := a.WorkloadClients(app)
+	if err != nil {
+		return nil, nil, microerror.Mask(err)
+	}
+
+	return c.K8sClient, c.CtrlClient, nil
+}
@@ -0,0 +1,267 @@
+package apptest
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const testKubeConfig = `apiVersion: v1
+kind: Config
+current-context: admin@management
+clusters:
+- name: management
+  cluster:
+    server: https://management.example.com
+- name: workload
+  cluster:
+    server: https://workload.example.com
+contexts:
+- name: admin@management
+  context:
+    cluster: management
+    user: admin
+- name: admin@workload
+  context:
+    cluster: workload
+    user: admin
+users:
+- name: admin
+  user:
+    client-certificate: admin.crt
+    client-key: admin.key
+`
+
+func writeTestKubeConfig(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"kubeconfig": testKubeConfig,
+		"admin.crt":  "certificate",
+		"admin.key":  "key",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatalf("expected nil got %#v", err)
+		}
+	}
+
+	return filepath.Join(dir, "kubeconfig")
+}
+
+func Test_remoteKubeConfig(t *testing.T) {
+	path := writeTestKubeConfig(t)
+
+	testCases := []struct {
+		name            string
+		app             App
+		expectedContext string
+		expectedServer  string
+		errorMatcher    func(error) bool
+	}{
+		{
+			name: "case 0: current context of the kubeconfig file is used by default",
+			app: App{
+				KubeConfigPath: path,
+				Name:           "test-app",
+			},
+			expectedContext: "admin@management",
+			expectedServer:  "https://management.example.com",
+		},
+		{
+			name: "case 1: selected context is used",
+			app: App{
+				KubeConfigContext: "admin@workload",
+				KubeConfigPath:    path,
+				Name:              "test-app",
+			},
+			expectedContext: "admin@workload",
+			expectedServer:  "https://workload.example.com",
+		},
+		{
+			name: "case 2: missing context is rejected",
+			app: App{
+				KubeConfigContext: "admin@other",
+				KubeConfigPath:    path,
+				Name:              "test-app",
+			},
+			errorMatcher: IsInvalidConfig,
+		},
+		{
+			name: "case 3: kubeconfig content and path are rejected",
+			app: App{
+				KubeConfig:     testKubeConfig,
+				KubeConfigPath: path,
+				Name:           "test-app",
+			},
+			errorMatcher: IsInvalidConfig,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kubeConfig, contextName, err := remoteKubeConfig(tc.app)
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+
+			if tc.errorMatcher != nil {
+				return
+			}
+
+			if contextName != tc.expectedContext {
+				t.Fatalf("expected context %#q got %#q", tc.expectedContext, contextName)
+			}
+
+			config, err := clientcmd.Load(kubeConfig)
+			if err != nil {
+				t.Fatalf("expected nil got %#v", err)
+			}
+			if len(config.Clusters) != 1 || len(config.Contexts) != 1 {
+				t.Fatalf("expected kubeconfig with a single context got %d clusters and %d contexts", len(config.Clusters), len(config.Contexts))
+			}
+
+			cluster := config.Clusters[config.Contexts[contextName].Cluster]
+			if cluster.Server != tc.expectedServer {
+				t.Fatalf("expected server %#q got %#q", tc.expectedServer, cluster.Server)
+			}
+
+			user := config.AuthInfos["admin"]
+			if string(user.ClientCertificateData) != "certificate" || user.ClientCertificate != "" {
+				t.Fatalf("expected client certificate to be inlined got %#v", user)
+			}
+		})
+	}
+}
+
+func Test_InstallApps_kubeConfigPath(t *testing.T) {
+	ctx := context.Background()
+
+	a := newTestAppSetup(t, Config{})
+
+	err := a.InstallApps(ctx, []App{
+		{
+			CatalogName:       "default",
+			KubeConfigContext: "admin@workload",
+			KubeConfigPath:    writeTestKubeConfig(t),
+			Name:              "test-app",
+			Namespace:         "test",
+			Version:           "1.0.0",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	var appCR v1alpha1.App
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: defaultNamespace}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if appCR.Spec.KubeConfig.InCluster {
+		t.Fatalf("expected app CR not to be in cluster")
+	}
+	if appCR.Spec.KubeConfig.Context.Name != "admin@workload" {
+		t.Fatalf("expected context %#q got %#q", "admin@workload", appCR.Spec.KubeConfig.Context.Name)
+	}
+
+	secret, err := a.k8sClient.CoreV1().Secrets(defaultNamespace).Get(ctx, "test-app-kubeconfig", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	config, err := clientcmd.Load(secret.Data["kubeConfig"])
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if config.CurrentContext != "admin@workload" {
+		t.Fatalf("expected current context %#q got %#q", "admin@workload", config.CurrentContext)
+	}
+}
+
+func Test_waitForReadyWorkloads_remote(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	app := App{
+		KubeConfigContext: "admin@workload",
+		KubeConfigPath:    writeTestKubeConfig(t),
+		Name:              "test-app",
+		Namespace:         "test",
+		WaitInterval:      10 * time.Millisecond,
+		WaitTimeout:       time.Second,
+	}
+
+	// The workload cluster has a deployment whose pod can't pull its image.
+	// The management cluster has none.
+	workloadK8sClient := k8sfake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					releaseNameAnnotation:      "test-app",
+					releaseNamespaceAnnotation: "test",
+				},
+				Name:      "test-app",
+				Namespace: "test",
+			},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "test-app"},
+				},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels:    map[string]string{"app": "test-app"},
+				Name:      "test-app-1",
+				Namespace: "test",
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name: "test-app",
+						State: corev1.ContainerState{
+							Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull"},
+						},
+					},
+				},
+			},
+		},
+	)
+
+	a := newTestAppSetup(t, Config{})
+
+	kubeConfig, contextName, err := remoteKubeConfig(app)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	a.workloadClients[workloadClientsKey(kubeConfig, contextName)] = &WorkloadClients{
+		CtrlClient: a.ctrlClient,
+		K8sClient:  workloadK8sClient,
+	}
+
+	err = a.waitForReadyWorkloads(ctx, app)
+	if !IsWorkloadFailed(err) {
+		t.Fatalf("expected workload failed error got %#v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package apptest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// RunIDLabel is set to the run ID on every object a run-scoped app
+	// setup applies.
+	RunIDLabel = "apptest.giantswarm.io/run-id"
+)
+
+// RunNamespace returns the namespace of a run-scoped app setup. It is empty
+// otherwise.
+func (a *AppSetup) RunNamespace() string {
+	return a.runNamespace
+}
+
+// newRunID returns a random run ID usable in namespace names.
+func newRunID() string {
+	return utilrand.String(8)
+}
+
+// runNamespaceName returns the name of the namespace of the run.
+func runNamespaceName(runID string) string {
+	return fmt.Sprintf("apptest-%s", runID)
+}
+
+// validateRunID returns invalidConfigError when the run ID can't be used in
+// the name of the namespace of the run or as label value.
+func validateRunID(runID string) error {
+	errs := validation.IsDNS1123Label(runNamespaceName(runID))
+	errs = append(errs, validation.IsValidLabelValue(runID)...)
+	if len(errs) > 0 {
+		return microerror.Maskf(invalidConfigError, "run ID %#q is invalid: %s", runID, strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// scopeApps puts the app CRs of the apps in the namespace of the run when
+// the app setup is run-scoped. Apps installed in the cluster of the app
+// setup without target namespace are installed in it too. Explicitly set
+// target namespaces and the ones of apps installed in remote clusters are
+// kept.
+func (a *AppSetup) scopeApps(apps []App) []App {
+	if a.runID == "" {
+		return apps
+	}
+
+	scoped := make([]App, len(apps))
+	for i, app := range apps {
+		app.AppCRNamespace = a.runNamespace
+		if app.Namespace == "" && !isRemote(app) {
+			app.Namespace = a.runNamespace
+		}
+
+		scoped[i] = app
+	}
+
+	return scoped
+}
+
+// catalogNamespace returns the namespace of catalog CRs which is the
+// namespace of the run for run-scoped app setups.
+func (a *AppSetup) catalogNamespace() string {
+	if a.runID != "" {
+		return a.runNamespace
+	}
+
+	return metav1.NamespaceDefault
+}
+
+// runLabels adds the run ID label to the labels of an object when the app
+// setup is run-scoped.
+func (a *AppSetup) runLabels(labels map[string]string) map[string]string {
+	if a.runID == "" {
+		return labels
+	}
+
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[RunIDLabel] = a.runID
+
+	return labels
+}
+
+// ensureRunNamespace applies the namespace of the run when the app setup is
+// run-scoped. It is recorded in the inventory first so it is deleted last
+// by CleanUp.
+func (a *AppSetup) ensureRunNamespace(ctx context.Context) error {
+	if a.runID == "" {
+		return nil
+	}
+
+	namespace := &corev1.Namespace{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       kindNamespace,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   a.runNamespace,
+			Labels: a.runLabels(nil),
+		},
+	}
+
+	err := a.applyNamespace(ctx, namespace)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
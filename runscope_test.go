@@ -0,0 +1,144 @@
+package apptest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/micrologger/microloggertest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_InstallApps_runScoped(t *testing.T) {
+	ctx := context.Background()
+
+	a := newTestAppSetup(t, Config{RunScoped: true, RunID: "abc123"})
+
+	if a.RunNamespace() != "apptest-abc123" {
+		t.Fatalf("expected run namespace %#q got %#q", "apptest-abc123", a.RunNamespace())
+	}
+
+	app := App{
+		AppCRNamespace: "giantswarm",
+		CatalogName:    "default",
+		Name:           "test-app",
+		Namespace:      "kube-system",
+		ValuesYAML:     "replicas: 2",
+		Version:        "1.0.0",
+	}
+
+	installed, err := a.InstallAppsWithResult(ctx, []App{app})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if installed[0].AppCR.Namespace != "apptest-abc123" {
+		t.Fatalf("expected app CR namespace %#q got %#q", "apptest-abc123", installed[0].AppCR.Namespace)
+	}
+
+	namespace, err := a.k8sClient.CoreV1().Namespaces().Get(ctx, "apptest-abc123", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if namespace.Labels[RunIDLabel] != "abc123" {
+		t.Fatalf("expected run ID label %#q got %#v", "abc123", namespace.Labels)
+	}
+
+	var appCR v1alpha1.App
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: "apptest-abc123"}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if appCR.Spec.Namespace != "kube-system" || appCR.Spec.CatalogNamespace != "apptest-abc123" {
+		t.Fatalf("expected target namespace %#q and catalog namespace %#q got %#q and %#q", "kube-system", "apptest-abc123", appCR.Spec.Namespace, appCR.Spec.CatalogNamespace)
+	}
+	if appCR.Labels[RunIDLabel] != "abc123" {
+		t.Fatalf("expected run ID label %#q got %#v", "abc123", appCR.Labels)
+	}
+
+	var catalog v1alpha1.Catalog
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "default", Namespace: "apptest-abc123"}, &catalog)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if catalog.Labels[RunIDLabel] != "abc123" {
+		t.Fatalf("expected run ID label %#q got %#v", "abc123", catalog.Labels)
+	}
+
+	configMap, err := a.k8sClient.CoreV1().ConfigMaps("apptest-abc123").Get(ctx, "test-app-user-values", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if configMap.Labels[RunIDLabel] != "abc123" {
+		t.Fatalf("expected run ID label %#q got %#v", "abc123", configMap.Labels)
+	}
+
+	err = a.CleanUp(ctx, []App{app})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	_, err = a.k8sClient.CoreV1().Namespaces().Get(ctx, "apptest-abc123", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected run namespace to be deleted got %#v", err)
+	}
+
+	// The cluster-scoped appcatalog CR is shared with other runs.
+	var appCatalog v1alpha1.AppCatalog
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "default"}, &appCatalog)
+	if err != nil {
+		t.Fatalf("expected shared appcatalog CR to be kept got %#v", err)
+	}
+	if _, ok := appCatalog.Labels[RunIDLabel]; ok {
+		t.Fatalf("expected shared appcatalog CR without run ID label got %#v", appCatalog.Labels)
+	}
+}
+
+func Test_scopeApps(t *testing.T) {
+	a := newTestAppSetup(t, Config{RunScoped: true, RunID: "abc123"})
+
+	scoped := a.scopeApps([]App{
+		{
+			Name: "test-app",
+		},
+		{
+			Name:      "system-app",
+			Namespace: "kube-system",
+		},
+		{
+			KubeConfig: "apiVersion: v1",
+			Name:       "remote-app",
+		},
+	})
+
+	if scoped[0].AppCRNamespace != "apptest-abc123" || scoped[0].Namespace != "apptest-abc123" {
+		t.Fatalf("expected app CR and target namespace %#q got %#q and %#q", "apptest-abc123", scoped[0].AppCRNamespace, scoped[0].Namespace)
+	}
+	// Explicitly set target namespaces are kept.
+	if scoped[1].AppCRNamespace != "apptest-abc123" || scoped[1].Namespace != "kube-system" {
+		t.Fatalf("expected app CR namespace %#q and target namespace %#q got %#q and %#q", "apptest-abc123", "kube-system", scoped[1].AppCRNamespace, scoped[1].Namespace)
+	}
+	// Remote apps are installed in the namespace of another cluster.
+	if scoped[2].AppCRNamespace != "apptest-abc123" || scoped[2].Namespace != "" {
+		t.Fatalf("expected app CR namespace %#q and no target namespace got %#q and %#q", "apptest-abc123", scoped[2].AppCRNamespace, scoped[2].Namespace)
+	}
+}
+
+func Test_New_invalidRunID(t *testing.T) {
+	for _, runID := range []string{"ABC", "abc_123", "abc-", strings.Repeat("a", 64)} {
+		_, err := New(Config{
+			CtrlClient: ctrlfake.NewFakeClient(),
+			K8sClient:  k8sfake.NewSimpleClientset(),
+			Logger:     microloggertest.New(),
+			RunScoped:  true,
+			RunID:      runID,
+		})
+		if !IsInvalidConfig(err) {
+			t.Fatalf("expected invalid config error for run ID %#q got %#v", runID, err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package simulator provides an in-process stand-in for app-operator and
+// chart-operator. It sets the status of App CRs according to configurable
+// rules so the InstallApps and UpgradeApp flows of apptest can be tested
+// with envtest or a fake client and no app platform installed.
+package simulator
+
+import (
+	"context"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	deployedStatus = "deployed"
+)
+
+const (
+	defaultResyncPeriod = 1 * time.Second
+)
+
+// Config represents the configuration used to create a simulator.
+type Config struct {
+	CtrlClient client.Client
+	Logger     micrologger.Logger
+
+	// ResyncPeriod is how often Run lists all App CRs. Defaults to 1 second.
+	ResyncPeriod time.Duration
+	// Rules are matched in order against every App CR. App CRs matching no
+	// rule are marked as deployed with their desired version.
+	Rules []Rule
+}
+
+// Rule defines the status the simulator sets for matching App CRs.
+type Rule struct {
+	// Name matches the app name in the App CR spec. Empty matches all apps.
+	Name string
+	// Version matches the version in the App CR spec. Empty matches all
+	// versions.
+	Version string
+
+	// Status is set as the release status, e.g. deployed or failed.
+	Status string
+	// Reason is set as the release reason.
+	Reason string
+	// StatusVersion is set as the status version. Defaults to the version in
+	// the App CR spec.
+	StatusVersion string
+}
+
+// Reconciler sets the status of App CRs the way app-operator and
+// chart-operator would.
+type Reconciler struct {
+	ctrlClient client.Client
+	logger     micrologger.Logger
+
+	resyncPeriod time.Duration
+	rules        []Rule
+}
+
+// New creates a new configured simulator.
+func New(config Config) (*Reconciler, error) {
+	if config.CtrlClient == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.CtrlClient must not be empty", config)
+	}
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+
+	if config.ResyncPeriod == 0 {
+		config.ResyncPeriod = defaultResyncPeriod
+	}
+
+	for i, rule := range config.Rules {
+		if rule.Status == "" {
+			return nil, microerror.Maskf(invalidConfigError, "%T.Rules[%d].Status must not be empty", config, i)
+		}
+	}
+
+	r := &Reconciler{
+		ctrlClient: config.CtrlClient,
+		logger:     config.Logger,
+
+		resyncPeriod: config.ResyncPeriod,
+		rules:        config.Rules,
+	}
+
+	return r, nil
+}
+
+// Run lists and reconciles all App CRs every resync period until the context
+// is done. It works with any client including the fake controller-runtime
+// client which cannot watch. Errors are logged and retried on the next resync
+// so a single failed request does not stop the simulator. Run returns nil
+// once the context is done.
+func (r *Reconciler) Run(ctx context.Context) error {
+	t := time.NewTicker(r.resyncPeriod)
+	defer t.Stop()
+
+	for {
+		r.resync(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func (r *Reconciler) resync(ctx context.Context) {
+	var apps v1alpha1.AppList
+
+	err := r.ctrlClient.List(ctx, &apps)
+	if err != nil {
+		r.logger.Errorf(ctx, err, "failed to list app CRs: retrying in %s", r.resyncPeriod)
+		return
+	}
+
+	for _, app := range apps.Items {
+		err = r.reconcile(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace})
+		if err != nil {
+			r.logger.Errorf(ctx, err, "failed to reconcile '%s/%s' app CR: retrying in %s", app.Namespace, app.Name, r.resyncPeriod)
+		}
+	}
+}
+
+// Reconcile implements reconcile.Reconciler so the simulator can also be
+// registered as a controller watching App CRs, e.g. against envtest.
+func (r *Reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	err := r.reconcile(context.Background(), req.NamespacedName)
+	if err != nil {
+		return reconcile.Result{}, microerror.Mask(err)
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, key types.NamespacedName) error {
+	var app v1alpha1.App
+
+	err := r.ctrlClient.Get(ctx, key, &app)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if !app.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	desired := r.desiredStatus(app)
+	if app.Status.Version == desired.Version && app.Status.Release.Status == desired.Release.Status && app.Status.Release.Reason == desired.Release.Reason {
+		return nil
+	}
+
+	r.logger.Debugf(ctx, "setting '%s/%s' app CR status to %#q with version %#q", app.Namespace, app.Name, desired.Release.Status, desired.Version)
+
+	app.Status = desired
+
+	err = r.ctrlClient.Status().Update(ctx, &app)
+	if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+		// The App CR changed in the meantime. It is reconciled again on
+		// the next event or resync.
+		return nil
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (r *Reconciler) desiredStatus(app v1alpha1.App) v1alpha1.AppStatus {
+	rule := Rule{
+		Status: deployedStatus,
+	}
+	for _, candidate := range r.rules {
+		if candidate.Name != "" && candidate.Name != app.Spec.Name {
+			continue
+		}
+		if candidate.Version != "" && candidate.Version != app.Spec.Version {
+			continue
+		}
+
+		rule = candidate
+		break
+	}
+
+	version := rule.StatusVersion
+	if version == "" {
+		version = app.Spec.Version
+	}
+
+	status := v1alpha1.AppStatus{
+		AppVersion: version,
+		Release: v1alpha1.AppStatusRelease{
+			Reason: rule.Reason,
+			Status: rule.Status,
+		},
+		Version: version,
+	}
+	if rule.Status == deployedStatus {
+		status.Release.LastDeployed = metav1.Now()
+	}
+
+	return status
+}
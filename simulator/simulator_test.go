@@ -0,0 +1,409 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/micrologger/microloggertest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/giantswarm/apptest"
+	"github.com/giantswarm/apptest/fake"
+)
+
+const (
+	testIndexYAML = `apiVersion: v1
+entries:
+  test-app:
+  - created: "2021-08-01T10:00:00Z"
+    name: test-app
+    urls:
+    - test-app-1.0.0.tgz
+    version: 1.0.0
+  - created: "2021-08-02T10:00:00Z"
+    name: test-app
+    urls:
+    - test-app-1.1.0.tgz
+    version: 1.1.0
+`
+)
+
+// reconcilingClient reconciles App CRs right after they are written so tests
+// do not depend on the resync period.
+type reconcilingClient struct {
+	client.Client
+	reconciler *Reconciler
+}
+
+func (c *reconcilingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	err := c.Client.Create(ctx, obj, opts...)
+	if err != nil {
+		return err
+	}
+
+	return c.reconcileApp(obj)
+}
+
+func (c *reconcilingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	err := c.Client.Update(ctx, obj, opts...)
+	if err != nil {
+		return err
+	}
+
+	return c.reconcileApp(obj)
+}
+
+func (c *reconcilingClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	if err != nil {
+		return err
+	}
+
+	return c.reconcileApp(obj)
+}
+
+func (c *reconcilingClient) reconcileApp(obj runtime.Object) error {
+	// App CRs are applied as unstructured objects.
+	_, ok := obj.(*v1alpha1.App)
+	if !ok && obj.GetObjectKind().GroupVersionKind().Kind != "App" {
+		return nil
+	}
+
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.reconciler.Reconcile(reconcile.Request{NamespacedName: key})
+
+	return err
+}
+
+// newClientset returns a fake clientset supporting server-side apply.
+func newClientset() *k8sfake.Clientset {
+	clientset := k8sfake.NewSimpleClientset()
+	fake.AddApplyReactor(clientset)
+
+	return clientset
+}
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	s := runtime.NewScheme()
+
+	err := clientgoscheme.AddToScheme(s)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	err = v1alpha1.AddToScheme(s)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	return s
+}
+
+func Test_InstallAndUpgrade(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testIndexYAML)
+	}))
+	defer server.Close()
+
+	s := newScheme(t)
+	ctrlClient := ctrlfake.NewFakeClientWithScheme(s)
+
+	r, err := New(Config{
+		CtrlClient: ctrlClient,
+		Logger:     microloggertest.New(),
+	})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	appTest, err := apptest.New(apptest.Config{
+		CtrlClient: &reconcilingClient{Client: fake.NewApplyClient(ctrlClient, s), reconciler: r},
+		K8sClient:  newClientset(),
+		Logger:     microloggertest.New(),
+		Scheme:     s,
+	})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	current := apptest.App{
+		CatalogName:   "test-catalog",
+		CatalogURL:    server.URL,
+		Name:          "test-app",
+		Namespace:     "giantswarm",
+		Version:       "1.0.0",
+		WaitForDeploy: true,
+	}
+
+	err = appTest.InstallApps(ctx, []apptest.App{current})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	desired := current
+	desired.Version = "1.1.0"
+
+	err = appTest.UpgradeApp(ctx, current, desired)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	var app v1alpha1.App
+	err = ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: "giantswarm"}, &app)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	if app.Status.Version != "1.1.0" {
+		t.Fatalf("expected version %#q got %#q", "1.1.0", app.Status.Version)
+	}
+	if app.Status.Release.Status != deployedStatus {
+		t.Fatalf("expected status %#q got %#q", deployedStatus, app.Status.Release.Status)
+	}
+}
+
+func Test_UpgradePath(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testIndexYAML)
+	}))
+	defer server.Close()
+
+	s := newScheme(t)
+	ctrlClient := ctrlfake.NewFakeClientWithScheme(s)
+
+	// The rollback to 1.0.0 is simulated as failed.
+	r, err := New(Config{
+		CtrlClient: ctrlClient,
+		Logger:     microloggertest.New(),
+		Rules: []Rule{
+			{Name: "test-app", Version: "1.0.0", Status: "failed", Reason: "rollback failed"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	appTest, err := apptest.New(apptest.Config{
+		CtrlClient: &reconcilingClient{Client: fake.NewApplyClient(ctrlClient, s), reconciler: r},
+		K8sClient:  newClientset(),
+		Logger:     microloggertest.New(),
+		Scheme:     s,
+	})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	steps := []apptest.App{
+		{
+			CatalogName: "test-catalog",
+			CatalogURL:  server.URL,
+			Name:        "test-app",
+			Namespace:   "giantswarm",
+			Version:     "1.1.0",
+		},
+		{
+			CatalogName: "test-catalog",
+			CatalogURL:  server.URL,
+			Name:        "test-app",
+			Namespace:   "giantswarm",
+			Version:     "1.0.0",
+		},
+	}
+
+	err = appTest.UpgradePath(ctx, steps)
+	if err == nil {
+		t.Fatalf("expected error got nil")
+	}
+	if !strings.Contains(err.Error(), "step 2 of 2") {
+		t.Fatalf("expected error to name step 2 got %#q", err.Error())
+	}
+}
+
+func Test_Rules(t *testing.T) {
+	testCases := []struct {
+		name           string
+		rules          []Rule
+		expectedStatus string
+		expectedReason string
+	}{
+		{
+			name:           "case 0: app matching no rule is deployed",
+			expectedStatus: deployedStatus,
+		},
+		{
+			name: "case 1: app matching name is failed",
+			rules: []Rule{
+				{Name: "other-app", Status: deployedStatus},
+				{Name: "test-app", Status: "failed", Reason: "chart not found"},
+			},
+			expectedStatus: "failed",
+			expectedReason: "chart not found",
+		},
+		{
+			name: "case 2: rule for other version is ignored",
+			rules: []Rule{
+				{Version: "2.0.0", Status: "not-installed"},
+			},
+			expectedStatus: deployedStatus,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ctrlClient := ctrlfake.NewFakeClientWithScheme(newScheme(t), &v1alpha1.App{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-app",
+					Namespace: "giantswarm",
+				},
+				Spec: v1alpha1.AppSpec{
+					Name:    "test-app",
+					Version: "1.0.0",
+				},
+			})
+
+			r, err := New(Config{
+				CtrlClient:   ctrlClient,
+				Logger:       microloggertest.New(),
+				ResyncPeriod: 10 * time.Millisecond,
+				Rules:        tc.rules,
+			})
+			if err != nil {
+				t.Fatalf("expected nil got %#v", err)
+			}
+
+			done := make(chan error)
+			go func() {
+				done <- r.Run(ctx)
+			}()
+
+			var app v1alpha1.App
+			for i := 0; i < 100; i++ {
+				err = ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: "giantswarm"}, &app)
+				if err != nil {
+					t.Fatalf("expected nil got %#v", err)
+				}
+				if app.Status.Release.Status != "" {
+					break
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			cancel()
+			err = <-done
+			if err != nil {
+				t.Fatalf("expected nil got %#v", err)
+			}
+
+			if app.Status.Release.Status != tc.expectedStatus {
+				t.Fatalf("expected status %#q got %#q", tc.expectedStatus, app.Status.Release.Status)
+			}
+			if app.Status.Release.Reason != tc.expectedReason {
+				t.Fatalf("expected reason %#q got %#q", tc.expectedReason, app.Status.Release.Reason)
+			}
+			if app.Status.Version != "1.0.0" {
+				t.Fatalf("expected version %#q got %#q", "1.0.0", app.Status.Version)
+			}
+		})
+	}
+}
+
+// failingClient fails the first List calls like an unavailable API server.
+type failingClient struct {
+	client.Client
+
+	mutex    sync.Mutex
+	failures int
+}
+
+func (c *failingClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.failures > 0 {
+		c.failures--
+		return fmt.Errorf("api server unavailable")
+	}
+
+	return c.Client.List(ctx, list, opts...)
+}
+
+func Test_Run_retriesErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctrlClient := &failingClient{
+		Client: ctrlfake.NewFakeClientWithScheme(newScheme(t), &v1alpha1.App{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-app",
+				Namespace: "giantswarm",
+			},
+			Spec: v1alpha1.AppSpec{
+				Name:    "test-app",
+				Version: "1.0.0",
+			},
+		}),
+		failures: 3,
+	}
+
+	r, err := New(Config{
+		CtrlClient:   ctrlClient,
+		Logger:       microloggertest.New(),
+		ResyncPeriod: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- r.Run(ctx)
+	}()
+
+	var app v1alpha1.App
+	for i := 0; i < 100; i++ {
+		err = ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: "giantswarm"}, &app)
+		if err != nil {
+			t.Fatalf("expected nil got %#v", err)
+		}
+		if app.Status.Release.Status != "" {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	if app.Status.Release.Status != deployedStatus {
+		t.Fatalf("expected status %#q got %#q", deployedStatus, app.Status.Release.Status)
+	}
+}
@@ -2,7 +2,9 @@ package apptest
 
 import (
 	"context"
+	"time"
 
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
@@ -10,15 +12,43 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// CatalogTypeHelm is the type of catalogs served as Helm repositories
+	// with an index.yaml.
+	CatalogTypeHelm = "helm"
+	// CatalogTypeOCI is the type of catalogs stored in an OCI registry.
+	CatalogTypeOCI = "oci"
+)
+
 type Interface interface {
 	// InstallApps creates appcatalog and app CRs for use in automated tests
-	// and ensures they are installed by our app platform.
+	// and ensures they are installed by our app platform. Apps are installed
+	// concurrently once the apps they depend on are installed.
 	InstallApps(ctx context.Context, apps []App) error
 
+	// InstallAppsWithResult installs the apps like InstallApps and returns
+	// the installed apps in the same order, e.g. with the versions SHAs and
+	// version constraints were resolved to.
+	InstallAppsWithResult(ctx context.Context, apps []App) ([]InstalledApp, error)
+
 	// UpgradeApp find matching current app CR and change the spec
 	// to follow desired app CR.
 	UpgradeApp(ctx context.Context, current, desired App) error
 
+	// UpgradePath installs the first app and then updates it to every
+	// following app in order, waiting for the app to be deployed after each
+	// step. The returned error names the step which failed.
+	UpgradePath(ctx context.Context, steps []App) error
+
+	// RollbackApp installs the current app, upgrades it to the desired app
+	// and rolls it back to the current app.
+	RollbackApp(ctx context.Context, current, desired App) error
+
+	// UninstallApp deletes the app CR and waits until the Helm release and
+	// the resources of the app are gone. Resources left behind, e.g. CRDs or
+	// persistent volume claims, are returned in a LeftoverError.
+	UninstallApp(ctx context.Context, app App) error
+
 	// EnsureCRDs will register the passed CRDs in the k8s API used by the client.
 	EnsureCRDs(ctx context.Context, crds []*apiextensionsv1.CustomResourceDefinition) error
 
@@ -28,26 +58,122 @@ type Interface interface {
 	// CtrlClient returns a controller-runtime client for use in automated tests.
 	CtrlClient() client.Client
 
-	// CleanUp removes created resources while installing apps.
+	// WorkloadClients returns the clients of the remote cluster the app is
+	// installed in, e.g. to assert on resources deployed there.
+	WorkloadClients(app App) (*WorkloadClients, error)
+
+	// CleanUp deletes every object created by the app setup, e.g. catalog,
+	// app CRs, kubeconfig secrets and user values config maps. App CRs are
+	// deleted first and their workloads waited for concurrently, the other
+	// objects are deleted afterwards in reverse creation order.
+	// App CRs of the given apps are deleted as well when they were created
+	// by another app setup.
 	CleanUp(ctx context.Context, apps []App) error
 
 	// RESTConfig returns a Kubernetes REST config for use in automated tests.
 	RESTConfig() *rest.Config
+
+	// MergedValues returns the values app-operator merged from the catalog,
+	// cluster and user config of the app, read from the config map and
+	// secret referenced by its chart CR.
+	MergedValues(ctx context.Context, app App) (map[string]interface{}, error)
 }
 
 type App struct {
 	AppCRName          string
 	AppCRNamespace     string
 	AppOperatorVersion string
-	CatalogName        string
-	CatalogURL         string
-	KubeConfig         string
-	Name               string
-	Namespace          string
-	SHA                string
-	ValuesYAML         string
-	Version            string
-	WaitForDeploy      bool
+	// CatalogConfig references catalog-level config set on the catalog CR
+	// when it is created.
+	CatalogConfig v1alpha1.CatalogSpecConfig
+	CatalogName   string
+	// CatalogType is the storage type of the catalog, either helm or oci.
+	// Defaults to oci for oci:// catalog URLs and helm otherwise. Versions
+	// in OCI catalogs are resolved by listing the registry tags.
+	CatalogType string
+	CatalogURL  string
+	// ChartPath is a local chart directory or chart tarball to install
+	// instead of a chart from a catalog. The chart is served by an
+	// in-process Helm repository and must be named after the app. The
+	// catalog name defaults to apptest-local and the version to the chart
+	// version.
+	ChartPath string
+	// Config references cluster-level config set as the app CR spec
+	// config.
+	Config v1alpha1.AppSpecConfig
+	// DependsOn holds the names of apps in the same InstallApps call which
+	// must be deployed before this app is installed. They are waited for
+	// even without WaitForDeploy.
+	DependsOn []string
+	// KubeConfig is the content of a kubeconfig of a remote cluster the app
+	// is installed in. Its context must be named <name>-kubeconfig unless
+	// KubeConfigContext is set.
+	KubeConfig string
+	// KubeConfigPath is the path of a kubeconfig file of a remote cluster the
+	// app is installed in. It is used instead of KubeConfig. Certificate
+	// files it references are inlined in the kubeconfig secret.
+	KubeConfigPath string
+	// KubeConfigContext is the context of the kubeconfig used to reach the
+	// remote cluster. Defaults to the current context of the kubeconfig file
+	// set as KubeConfigPath.
+	KubeConfigContext string
+	Name              string
+	Namespace         string
+	// SecretValues are marshalled to YAML and used as SecretValuesYAML.
+	SecretValues interface{}
+	// SecretValuesYAML is stored in the <name>-user-secrets secret which is
+	// referenced as user config of the app CR.
+	SecretValuesYAML string
+	SHA              string
+	// Values are marshalled to YAML and used as ValuesYAML, e.g. a struct
+	// with JSON tags or a map.
+	Values interface{}
+	// ValuesYAML is stored in the <name>-user-values config map which is
+	// referenced as user config of the app CR.
+	ValuesYAML string
+	// Version is an exact version or a semver constraint such as ^2.3,
+	// ~1.5.0 or >=3.0.0 <4.0.0 which resolves to the highest matching
+	// version in the catalog.
+	Version       string
+	WaitForDeploy bool
+	// WaitForReady also waits until the Deployments, StatefulSets,
+	// DaemonSets and Jobs of the Helm release are rolled out and ready
+	// once the app is deployed. It implies WaitForDeploy.
+	WaitForReady bool
+	// WaitInterval overrides the interval between app CR status checks
+	// configured in Config.
+	WaitInterval time.Duration
+	// WaitTimeout overrides how long to wait for the app to be deployed
+	// configured in Config.
+	WaitTimeout time.Duration
+}
+
+// InstalledApp is the result of installing an app.
+type InstalledApp struct {
+	// App is the app as it was installed, e.g. pointing at the catalog of
+	// a local chart.
+	App App
+	// Version is the version the app CR was created with. SHAs and version
+	// constraints are resolved against the catalog.
+	Version string
+	// CatalogURL is the URL of the catalog the app was installed from.
+	CatalogURL string
+	// AppCR is the key of the app CR.
+	AppCR client.ObjectKey
+	// KubeConfigSecretName is the name of the secret holding the kubeconfig
+	// of a remote cluster. It is empty for in-cluster apps.
+	KubeConfigSecretName string
+	// UserConfigMapName and UserSecretName are the names of the config map
+	// and secret holding the user values. They are empty when the app has
+	// no values.
+	UserConfigMapName string
+	UserSecretName    string
+	// Duration is the time taken to create the app CR and wait until it is
+	// deployed.
+	Duration time.Duration
+	// Release is the release status of the app CR once it was installed.
+	// Apps not waited for may not be deployed yet.
+	Release v1alpha1.AppStatusRelease
 }
 
 // schemeBuilder is used to extend the known types of the client-go scheme.
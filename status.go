@@ -0,0 +1,100 @@
+package apptest
+
+import (
+	"regexp"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	// defaultStuckAfter is how long an app CR may have a pending status
+	// before it is considered stuck.
+	defaultStuckAfter = 10 * time.Minute
+)
+
+// TerminalStatus matches app CR release statuses which won't turn into
+// deployed without intervention. Waiting for an app stops with an error
+// asserted by IsTerminalStatus as soon as its app CR matches.
+type TerminalStatus struct {
+	// Status is the release status to match exactly. Every status matches
+	// when empty.
+	Status string
+	// Reason is a regular expression matched against the release reason.
+	// Every reason matches when empty.
+	Reason string
+	// StuckAfter makes the status terminal only once the app CR had it for
+	// the given duration, e.g. for pending-install.
+	StuckAfter time.Duration
+}
+
+// DefaultTerminalStatuses returns the terminal statuses and reasons set by
+// app-operator and chart-operator. The failed and not-installed statuses are
+// always terminal and asserted by IsAppFailed.
+func DefaultTerminalStatuses() []TerminalStatus {
+	return []TerminalStatus{
+		{Status: "already-exists"},
+		{Status: "chart-pull-failed"},
+		{Status: "invalid-manifest"},
+		{Status: "validation-failed"},
+		{Reason: `(?i)chart.* not found`},
+		{Reason: `(?i)no chart version found`},
+		{Reason: `(?i)catalog.* not found`},
+		{Status: "pending-install", StuckAfter: defaultStuckAfter},
+		{Status: "pending-upgrade", StuckAfter: defaultStuckAfter},
+		{Status: "pending-rollback", StuckAfter: defaultStuckAfter},
+	}
+}
+
+// statusClassifier matches app CR release statuses against terminal
+// statuses.
+type statusClassifier []terminalStatus
+
+type terminalStatus struct {
+	TerminalStatus
+	reason *regexp.Regexp
+}
+
+func newStatusClassifier(statuses []TerminalStatus) (statusClassifier, error) {
+	var c statusClassifier
+	for _, s := range statuses {
+		if s.Status == "" && s.Reason == "" {
+			return nil, microerror.Maskf(invalidConfigError, "terminal status must have a status or reason")
+		}
+
+		t := terminalStatus{TerminalStatus: s}
+		if s.Reason != "" {
+			reason, err := regexp.Compile(s.Reason)
+			if err != nil {
+				return nil, microerror.Maskf(invalidConfigError, "terminal status reason %#q is invalid: %s", s.Reason, err)
+			}
+
+			t.reason = reason
+		}
+
+		c = append(c, t)
+	}
+
+	return c, nil
+}
+
+// classify returns whether the release is terminal after the app CR had its
+// status for the given duration.
+func (c statusClassifier) classify(release v1alpha1.AppStatusRelease, since time.Duration) (TerminalStatus, bool) {
+	for _, t := range c {
+		if t.Status != "" && t.Status != release.Status {
+			continue
+		}
+		if t.reason != nil && !t.reason.MatchString(release.Reason) {
+			continue
+		}
+		if since < t.StuckAfter {
+			continue
+		}
+
+		return t.TerminalStatus, true
+	}
+
+	return TerminalStatus{}, false
+}
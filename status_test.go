@@ -0,0 +1,125 @@
+package apptest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_statusClassifier(t *testing.T) {
+	c, err := newStatusClassifier(append(DefaultTerminalStatuses(), TerminalStatus{Reason: "quota exceeded"}))
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		release  v1alpha1.AppStatusRelease
+		since    time.Duration
+		terminal bool
+	}{
+		{
+			name:     "case 0: chart pull failure is terminal",
+			release:  v1alpha1.AppStatusRelease{Status: "chart-pull-failed"},
+			terminal: true,
+		},
+		{
+			name:     "case 1: chart not found reason is terminal",
+			release:  v1alpha1.AppStatusRelease{Status: "unknown", Reason: "Chart test-app-1.0.0.tgz not found"},
+			terminal: true,
+		},
+		{
+			name:    "case 2: pending install is not terminal at first",
+			release: v1alpha1.AppStatusRelease{Status: "pending-install"},
+			since:   time.Minute,
+		},
+		{
+			name:     "case 3: pending install gets stuck",
+			release:  v1alpha1.AppStatusRelease{Status: "pending-install"},
+			since:    time.Hour,
+			terminal: true,
+		},
+		{
+			name:     "case 4: registered reason is terminal",
+			release:  v1alpha1.AppStatusRelease{Status: "pending-upgrade", Reason: "resource quota exceeded"},
+			terminal: true,
+		},
+		{
+			name:    "case 5: unknown status is not terminal",
+			release: v1alpha1.AppStatusRelease{Status: "pending-upgrade", Reason: "waiting"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, terminal := c.classify(tc.release, tc.since)
+			if terminal != tc.terminal {
+				t.Fatalf("expected terminal %t got %t", tc.terminal, terminal)
+			}
+		})
+	}
+}
+
+func Test_newStatusClassifier_invalid(t *testing.T) {
+	_, err := newStatusClassifier([]TerminalStatus{{Reason: "("}})
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error got %#v", err)
+	}
+
+	_, err = newStatusClassifier([]TerminalStatus{{StuckAfter: time.Minute}})
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error got %#v", err)
+	}
+}
+
+func Test_waitForDeployedApp_terminalStatus(t *testing.T) {
+	stuckApp := &v1alpha1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app",
+			Namespace: defaultNamespace,
+		},
+		Status: v1alpha1.AppStatus{
+			Release: v1alpha1.AppStatusRelease{
+				Status: "pending-install",
+			},
+		},
+	}
+
+	config := Config{
+		TerminalStatuses: []TerminalStatus{
+			{Status: "pending-install", StuckAfter: 50 * time.Millisecond},
+		},
+	}
+
+	a := newTestAppSetup(t, config, stuckApp)
+
+	app := App{
+		Name:         "test-app",
+		Version:      "1.0.0",
+		WaitInterval: 10 * time.Millisecond,
+		WaitTimeout:  10 * time.Second,
+	}
+
+	start := time.Now()
+
+	err := a.waitForDeployedApp(context.Background(), app)
+	if !IsTerminalStatus(err) {
+		t.Fatalf("expected terminal status error got %#v", err)
+	}
+	if !IsAppFailed(err) {
+		t.Fatalf("expected app failed error got %#v", err)
+	}
+
+	var appErr *AppError
+	if !errors.As(err, &appErr) || appErr.Status != "pending-install" {
+		t.Fatalf("expected app error with status %#q got %#v", "pending-install", appErr)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("expected stuck app to stop the wait got %s", time.Since(start))
+	}
+}
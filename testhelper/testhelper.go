@@ -0,0 +1,119 @@
+// Package testhelper wraps apptest for use in Go tests. Failures are reported
+// with the line numbers of the calling test and installed apps are cleaned up
+// automatically when the test finishes.
+package testhelper
+
+import (
+	"context"
+	"testing"
+
+	"github.com/giantswarm/apptest"
+)
+
+// New creates a run-scoped app setup for the test. Every test, e.g. every
+// parallel subtest, gets a namespace of its own so their apps don't collide.
+// Apps without Namespace are installed in it. The namespace is deleted when
+// the test finishes.
+func New(t testing.TB, config apptest.Config) *apptest.AppSetup {
+	t.Helper()
+
+	config.RunScoped = true
+
+	appTest, err := apptest.New(config)
+	if err != nil {
+		t.Fatalf("failed to create app setup: %s", err)
+	}
+
+	t.Logf("using run namespace %#q", appTest.RunNamespace())
+
+	t.Cleanup(func() {
+		err := appTest.CleanUp(context.Background(), nil)
+		if err != nil {
+			t.Errorf("failed to delete namespace %#q: %s", appTest.RunNamespace(), err)
+		}
+	})
+
+	return appTest
+}
+
+// MustInstallApps installs the apps and fails the test if any of them fails
+// to install. The apps are cleaned up when the test finishes.
+func MustInstallApps(ctx context.Context, t testing.TB, appTest apptest.Interface, apps []apptest.App) []apptest.InstalledApp {
+	t.Helper()
+
+	// Apps which were installed before another app failed are cleaned up
+	// too.
+	cleanUp(t, appTest, apps)
+
+	installed, err := appTest.InstallAppsWithResult(ctx, apps)
+	if err != nil {
+		t.Fatalf("failed to install apps: %s", err)
+	}
+
+	return installed
+}
+
+// MustUpgradeApp installs the current app, upgrades it to the desired app and
+// fails the test if any step fails. The app is cleaned up when the test
+// finishes.
+func MustUpgradeApp(ctx context.Context, t testing.TB, appTest apptest.Interface, current, desired apptest.App) {
+	t.Helper()
+
+	cleanUp(t, appTest, []apptest.App{desired})
+
+	err := appTest.UpgradeApp(ctx, current, desired)
+	if err != nil {
+		t.Fatalf("failed to upgrade app %#q: %s", desired.Name, err)
+	}
+}
+
+// MustUpgradePath applies every step of the upgrade path and fails the test
+// if any step fails. The app is cleaned up when the test finishes.
+func MustUpgradePath(ctx context.Context, t testing.TB, appTest apptest.Interface, steps []apptest.App) {
+	t.Helper()
+
+	if len(steps) > 0 {
+		cleanUp(t, appTest, steps[len(steps)-1:])
+	}
+
+	err := appTest.UpgradePath(ctx, steps)
+	if err != nil {
+		t.Fatalf("failed to apply upgrade path: %s", err)
+	}
+}
+
+// MustRollbackApp installs the current app, upgrades it to the desired app,
+// rolls it back and fails the test if any step fails. The app is cleaned up
+// when the test finishes.
+func MustRollbackApp(ctx context.Context, t testing.TB, appTest apptest.Interface, current, desired apptest.App) {
+	t.Helper()
+
+	cleanUp(t, appTest, []apptest.App{current})
+
+	err := appTest.RollbackApp(ctx, current, desired)
+	if err != nil {
+		t.Fatalf("failed to roll back app %#q: %s", current.Name, err)
+	}
+}
+
+// MustUninstallApp uninstalls the app and fails the test if it fails or
+// resources of the app are left behind.
+func MustUninstallApp(ctx context.Context, t testing.TB, appTest apptest.Interface, app apptest.App) {
+	t.Helper()
+
+	err := appTest.UninstallApp(ctx, app)
+	if err != nil {
+		t.Fatalf("failed to uninstall app %#q: %s", app.Name, err)
+	}
+}
+
+// cleanUp registers the clean up of the apps when the test finishes. The
+// context of the test may already be done by then so a new one is used.
+func cleanUp(t testing.TB, appTest apptest.Interface, apps []apptest.App) {
+	t.Cleanup(func() {
+		err := appTest.CleanUp(context.Background(), apps)
+		if err != nil {
+			t.Errorf("failed to clean up apps: %s", err)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package testhelper
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/micrologger/microloggertest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/apptest"
+	"github.com/giantswarm/apptest/fake"
+)
+
+func Test_MustInstallApps(t *testing.T) {
+	appTest, err := fake.New(fake.Config{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	apps := []apptest.App{
+		{
+			CatalogName:   "default",
+			Name:          "test-app",
+			Namespace:     "test",
+			Version:       "1.0.0",
+			WaitForDeploy: true,
+		},
+	}
+
+	t.Run("install", func(t *testing.T) {
+		installed := MustInstallApps(context.Background(), t, appTest, apps)
+		if len(installed) != 1 || installed[0].Version != "1.0.0" {
+			t.Fatalf("expected app with version %#q got %#v", "1.0.0", installed)
+		}
+	})
+
+	calls := appTest.Calls()
+	if len(calls.CleanUp) != 1 || calls.CleanUp[0][0].Name != "test-app" {
+		t.Fatalf("expected apps to be cleaned up after the test got %#v", calls.CleanUp)
+	}
+}
+
+func Test_New(t *testing.T) {
+	s := runtime.NewScheme()
+	err := clientgoscheme.AddToScheme(s)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	err = v1alpha1.AddToScheme(s)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	k8sClient := k8sfake.NewSimpleClientset()
+	fake.AddApplyReactor(k8sClient)
+
+	config := apptest.Config{
+		CtrlClient:   fake.NewApplyClient(ctrlfake.NewFakeClientWithScheme(s), s),
+		K8sClient:    k8sClient,
+		Logger:       microloggertest.New(),
+		Scheme:       s,
+		WaitInterval: time.Millisecond,
+	}
+
+	var mutex sync.Mutex
+	var namespaces []string
+
+	// Parallel subtests only finish, and are cleaned up, once the group
+	// returns.
+	t.Run("group", func(t *testing.T) {
+		for _, name := range []string{"first", "second"} {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				appTest := New(t, config)
+
+				mutex.Lock()
+				namespaces = append(namespaces, appTest.RunNamespace())
+				mutex.Unlock()
+
+				MustInstallApps(context.Background(), t, appTest, []apptest.App{
+					{
+						CatalogName: "default",
+						Name:        "test-app",
+						Version:     "1.0.0",
+					},
+				})
+			})
+		}
+	})
+
+	if len(namespaces) != 2 {
+		t.Fatalf("expected 2 namespaces got %#v", namespaces)
+	}
+	if namespaces[0] == namespaces[1] {
+		t.Fatalf("expected every test to get its own namespace got %#q twice", namespaces[0])
+	}
+
+	for _, namespace := range namespaces {
+		_, err = k8sClient.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{})
+		if !apierrors.IsNotFound(err) {
+			t.Fatalf("expected namespace %#q to be deleted got %#v", namespace, err)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package apptest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/backoff"
+	"github.com/giantswarm/microerror"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// UninstallApp deletes the app CR and waits until the Helm release and every
+// resource of the app are gone. Resources of the app are the ones annotated
+// with the Helm release which exist when the app is deleted, the CRDs of the
+// crds directory of its chart and the persistent volume claims of its
+// stateful sets. Resources left behind are returned in a LeftoverError.
+func (a *AppSetup) UninstallApp(ctx context.Context, app App) error {
+	app = a.scopeApps([]App{app})[0]
+
+	k8sClient, ctrlClient, err := a.appClients(app)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	crdNames, err := releaseCRDNames(ctx, k8sClient, app)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	resources, pvcPrefixes, err := listAppResources(ctx, k8sClient, ctrlClient, app, nil, crdNames)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	obj := inventoryObject{
+		kind:      kindApp,
+		name:      appCRName(app),
+		namespace: appCRNamespace(app),
+		app:       &app,
+	}
+
+	err = a.deleteObject(ctx, obj)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = a.waitForDeletedApp(ctx, app)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	a.inventory.remove(obj)
+
+	err = a.waitForDeletedRelease(ctx, k8sClient, app)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = a.waitForDeletedResources(ctx, k8sClient, ctrlClient, app, resources, pvcPrefixes, crdNames)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// waitForDeletedRelease waits until the secrets Helm stores the revisions of
+// the release in are gone.
+func (a *AppSetup) waitForDeletedRelease(ctx context.Context, k8sClient kubernetes.Interface, app App) error {
+	release := appCRName(app)
+
+	a.logger.Debugf(ctx, "waiting for release %#q in namespace %#q to be deleted", release, app.Namespace)
+
+	selector := releaseSelector(release)
+
+	o := func() error {
+		secrets, err := k8sClient.CoreV1().Secrets(app.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		if len(secrets.Items) > 0 {
+			return microerror.Maskf(executionFailedError, "%d revisions of release %#q still exist", len(secrets.Items), release)
+		}
+
+		return nil
+	}
+
+	n := func(err error, t time.Duration) {
+		a.logger.Debugf(ctx, "waiting for release %#q to be deleted: %s", release, err)
+	}
+
+	err := retryNotify(ctx, o, backoff.NewConstant(a.waitTimeout(app), a.waitInterval(app)), n)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	a.logger.Debugf(ctx, "waited for release %#q in namespace %#q to be deleted", release, app.Namespace)
+
+	return nil
+}
+
+// waitForDeletedResources waits until the resources which are being deleted
+// are gone. It stops as soon as one of them is not being deleted since Helm
+// sent every delete request before deleting the release.
+func (a *AppSetup) waitForDeletedResources(ctx context.Context, k8sClient kubernetes.Interface, ctrlClient client.Client, app App, resources map[Leftover]bool, pvcPrefixes []string, crdNames map[string]bool) error {
+	a.logger.Debugf(ctx, "waiting for %d resources of app %#q to be deleted", len(resources), app.Name)
+
+	var leftovers []Leftover
+
+	o := func() error {
+		current, _, err := listAppResources(ctx, k8sClient, ctrlClient, app, pvcPrefixes, crdNames)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		leftovers = nil
+		var terminating int
+		for r := range resources {
+			deleting, ok := current[r]
+			if !ok {
+				continue
+			}
+
+			leftovers = append(leftovers, r)
+			if deleting {
+				terminating++
+			}
+		}
+
+		sort.Slice(leftovers, func(i, j int) bool {
+			return leftovers[i].String() < leftovers[j].String()
+		})
+
+		if len(leftovers) > terminating {
+			return backoff.Permanent(&LeftoverError{Name: app.Name, Leftovers: leftovers})
+		} else if len(leftovers) > 0 {
+			return microerror.Maskf(executionFailedError, "%d resources are being deleted", len(leftovers))
+		}
+
+		return nil
+	}
+
+	n := func(err error, t time.Duration) {
+		a.logger.Debugf(ctx, "waiting for resources of app %#q to be deleted: %s", app.Name, err)
+	}
+
+	err := retryNotify(ctx, o, backoff.NewConstant(a.waitTimeout(app), a.waitInterval(app)), n)
+	if IsResourcesLeft(err) {
+		return microerror.Mask(err)
+	} else if err != nil && len(leftovers) > 0 {
+		return microerror.Mask(&LeftoverError{Name: app.Name, Leftovers: leftovers})
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	a.logger.Debugf(ctx, "waited for %d resources of app %#q to be deleted", len(resources), app.Name)
+
+	return nil
+}
+
+// listAppResources returns the resources of the app and whether they are
+// being deleted. Resources are matched by the annotations of the Helm release
+// so resources of other installs of the same chart are not. Namespaced
+// resources are listed in the target namespace of the app. Persistent volume
+// claims created for the volume claim templates of the app's stateful sets
+// are neither annotated nor deleted by Helm. They are matched by name prefix.
+// The prefixes of the stateful sets found are returned with the given ones so
+// claims are still matched once the stateful sets are gone. CRDs of the crds
+// directory of the chart aren't annotated either, they are matched by the
+// names returned by releaseCRDNames.
+func listAppResources(ctx context.Context, k8sClient kubernetes.Interface, ctrlClient client.Client, app App, pvcPrefixes []string, crdNames map[string]bool) (map[Leftover]bool, []string, error) {
+	release := appCRName(app)
+	resources := map[Leftover]bool{}
+
+	add := func(kind string, obj metav1.Object) {
+		if !inRelease(obj, app.Namespace, release) {
+			return
+		}
+
+		resources[Leftover{Kind: kind, Namespace: obj.GetNamespace(), Name: obj.GetName()}] = obj.GetDeletionTimestamp() != nil
+	}
+
+	if app.Namespace != "" {
+		namespace := app.Namespace
+		opts := metav1.ListOptions{}
+
+		configMaps, err := k8sClient.CoreV1().ConfigMaps(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		for i := range configMaps.Items {
+			add("ConfigMap", &configMaps.Items[i])
+		}
+
+		secrets, err := k8sClient.CoreV1().Secrets(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		for i := range secrets.Items {
+			add("Secret", &secrets.Items[i])
+		}
+
+		services, err := k8sClient.CoreV1().Services(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		for i := range services.Items {
+			add("Service", &services.Items[i])
+		}
+
+		serviceAccounts, err := k8sClient.CoreV1().ServiceAccounts(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		for i := range serviceAccounts.Items {
+			add("ServiceAccount", &serviceAccounts.Items[i])
+		}
+
+		deployments, err := k8sClient.AppsV1().Deployments(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		for i := range deployments.Items {
+			add("Deployment", &deployments.Items[i])
+		}
+
+		statefulSets, err := k8sClient.AppsV1().StatefulSets(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		for i, s := range statefulSets.Items {
+			add("StatefulSet", &statefulSets.Items[i])
+
+			if !inRelease(&s, namespace, release) {
+				continue
+			}
+			for _, t := range s.Spec.VolumeClaimTemplates {
+				pvcPrefixes = append(pvcPrefixes, fmt.Sprintf("%s-%s-", t.Name, s.Name))
+			}
+		}
+
+		daemonSets, err := k8sClient.AppsV1().DaemonSets(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		for i := range daemonSets.Items {
+			add("DaemonSet", &daemonSets.Items[i])
+		}
+
+		jobs, err := k8sClient.BatchV1().Jobs(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		for i := range jobs.Items {
+			add("Job", &jobs.Items[i])
+		}
+
+		roles, err := k8sClient.RbacV1().Roles(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		for i := range roles.Items {
+			add("Role", &roles.Items[i])
+		}
+
+		roleBindings, err := k8sClient.RbacV1().RoleBindings(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		for i := range roleBindings.Items {
+			add("RoleBinding", &roleBindings.Items[i])
+		}
+
+		pvcs, err := k8sClient.CoreV1().PersistentVolumeClaims(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, nil, microerror.Mask(err)
+		}
+		for i, p := range pvcs.Items {
+			add("PersistentVolumeClaim", &pvcs.Items[i])
+
+			for _, prefix := range pvcPrefixes {
+				if strings.HasPrefix(p.Name, prefix) {
+					resources[Leftover{Kind: "PersistentVolumeClaim", Namespace: p.Namespace, Name: p.Name}] = p.DeletionTimestamp != nil
+				}
+			}
+		}
+	}
+
+	namespaces, err := k8sClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, microerror.Mask(err)
+	}
+	for i := range namespaces.Items {
+		add("Namespace", &namespaces.Items[i])
+	}
+
+	clusterRoles, err := k8sClient.RbacV1().ClusterRoles().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, microerror.Mask(err)
+	}
+	for i := range clusterRoles.Items {
+		add("ClusterRole", &clusterRoles.Items[i])
+	}
+
+	clusterRoleBindings, err := k8sClient.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, microerror.Mask(err)
+	}
+	for i := range clusterRoleBindings.Items {
+		add("ClusterRoleBinding", &clusterRoleBindings.Items[i])
+	}
+
+	var crds apiextensionsv1.CustomResourceDefinitionList
+	err = ctrlClient.List(ctx, &crds)
+	if meta.IsNoMatchError(err) {
+		// Fall through.
+	} else if err != nil {
+		return nil, nil, microerror.Mask(err)
+	}
+	for i, crd := range crds.Items {
+		if crdNames[crd.Name] {
+			resources[Leftover{Kind: "CustomResourceDefinition", Name: crd.Name}] = crd.DeletionTimestamp != nil
+			continue
+		}
+
+		add("CustomResourceDefinition", &crds.Items[i])
+	}
+
+	return resources, pvcPrefixes, nil
+}
+
+// gzipMagic is the header of gzipped data.
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// helmRelease is the part of a Helm release stored in a release secret
+// apptest uses.
+type helmRelease struct {
+	Chart struct {
+		Files []struct {
+			Name string `json:"name"`
+			Data []byte `json:"data"`
+		} `json:"files"`
+	} `json:"chart"`
+}
+
+// releaseSelector returns the label selector of the secrets Helm stores the
+// revisions of the release in.
+func releaseSelector(release string) string {
+	return labels.SelectorFromSet(labels.Set{"name": release, "owner": "helm"}).String()
+}
+
+// releaseCRDNames returns the names of the CRDs in the crds directory of the
+// chart of every revision of the release of the app. Helm installs them
+// without release annotations and never deletes them.
+func releaseCRDNames(ctx context.Context, k8sClient kubernetes.Interface, app App) (map[string]bool, error) {
+	crdNames := map[string]bool{}
+
+	if app.Namespace == "" {
+		return crdNames, nil
+	}
+
+	secrets, err := k8sClient.CoreV1().Secrets(app.Namespace).List(ctx, metav1.ListOptions{LabelSelector: releaseSelector(appCRName(app))})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	for _, secret := range secrets.Items {
+		release, err := decodeRelease(secret.Data["release"])
+		if err != nil {
+			return nil, microerror.Maskf(executionFailedError, "failed to decode release secret '%s/%s': %s", secret.Namespace, secret.Name, err)
+		}
+
+		for _, f := range release.Chart.Files {
+			if !strings.HasPrefix(f.Name, "crds/") {
+				continue
+			}
+
+			err = addCRDNames(crdNames, f.Data)
+			if err != nil {
+				return nil, microerror.Maskf(executionFailedError, "failed to decode %#q of release secret '%s/%s': %s", f.Name, secret.Namespace, secret.Name, err)
+			}
+		}
+	}
+
+	return crdNames, nil
+}
+
+// decodeRelease decodes a release the way Helm stores it in release secrets,
+// as base64 encoded and optionally gzipped JSON.
+func decodeRelease(data []byte) (helmRelease, error) {
+	var release helmRelease
+
+	b, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return helmRelease{}, microerror.Mask(err)
+	}
+
+	if bytes.HasPrefix(b, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return helmRelease{}, microerror.Mask(err)
+		}
+		defer r.Close()
+
+		b, err = ioutil.ReadAll(r)
+		if err != nil {
+			return helmRelease{}, microerror.Mask(err)
+		}
+	}
+
+	err = json.Unmarshal(b, &release)
+	if err != nil {
+		return helmRelease{}, microerror.Mask(err)
+	}
+
+	return release, nil
+}
+
+// addCRDNames adds the names of the CRDs of the YAML documents to crdNames.
+func addCRDNames(crdNames map[string]bool, data []byte) error {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+
+	for {
+		var obj struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		}
+
+		err := decoder.Decode(&obj)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return microerror.Mask(err)
+		}
+
+		if obj.Kind == "CustomResourceDefinition" && obj.Metadata.Name != "" {
+			crdNames[obj.Metadata.Name] = true
+		}
+	}
+}
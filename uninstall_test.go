@@ -0,0 +1,235 @@
+package apptest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/apiextensions/v3/pkg/label"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// uninstallingClient deletes the deployments and stateful sets of a release
+// when its app CR is deleted like chart-operator does.
+type uninstallingClient struct {
+	client.Client
+
+	k8sClient kubernetes.Interface
+}
+
+func (c *uninstallingClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	err := c.Client.Delete(ctx, obj, opts...)
+	if err != nil {
+		return err
+	}
+
+	app, ok := obj.(*v1alpha1.App)
+	if !ok {
+		return nil
+	}
+
+	_ = c.k8sClient.AppsV1().Deployments("test").Delete(ctx, app.Name, metav1.DeleteOptions{})
+	_ = c.k8sClient.AppsV1().StatefulSets("test").Delete(ctx, app.Name, metav1.DeleteOptions{})
+	_ = c.k8sClient.CoreV1().Secrets("test").Delete(ctx, "sh.helm.release.v1."+app.Name+".v1", metav1.DeleteOptions{})
+
+	return nil
+}
+
+// newReleaseSecret returns the secret Helm stores the first revision of the
+// release in with the files of its chart.
+func newReleaseSecret(t *testing.T, release string, files map[string]string) *corev1.Secret {
+	t.Helper()
+
+	var r helmRelease
+	for name, data := range files {
+		r.Chart.Files = append(r.Chart.Files, struct {
+			Name string `json:"name"`
+			Data []byte `json:"data"`
+		}{Name: name, Data: []byte(data)})
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write(data)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				"name":  release,
+				"owner": "helm",
+			},
+			Name:      "sh.helm.release.v1." + release + ".v1",
+			Namespace: "test",
+		},
+		Data: map[string][]byte{
+			"release": []byte(base64.StdEncoding.EncodeToString(buf.Bytes())),
+		},
+	}
+}
+
+func Test_UninstallApp(t *testing.T) {
+	releaseAnnotations := map[string]string{
+		releaseNameAnnotation:      "test-app",
+		releaseNamespaceAnnotation: "test",
+	}
+
+	testCases := []struct {
+		name              string
+		statefulSet       bool
+		crd               bool
+		expectedLeftovers []Leftover
+	}{
+		{
+			name: "case 0: every resource is deleted",
+		},
+		{
+			name:        "case 1: kept CRD and persistent volume claim are left behind",
+			statefulSet: true,
+			crd:         true,
+			expectedLeftovers: []Leftover{
+				{Kind: "CustomResourceDefinition", Name: "tests.example.com"},
+				{Kind: "PersistentVolumeClaim", Namespace: "test", Name: "data-test-app-0"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			appCR := &v1alpha1.App{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-app",
+					Namespace: defaultNamespace,
+				},
+			}
+
+			a := newTestAppSetup(t, Config{WaitInterval: 10 * time.Millisecond}, appCR)
+			a.ctrlClient = &uninstallingClient{Client: a.ctrlClient, k8sClient: a.k8sClient}
+
+			// Objects of other installs of the chart are labelled with the
+			// app name too.
+			_, err := a.k8sClient.RbacV1().ClusterRoles().Create(ctx, &rbacv1.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{label.AppKubernetesName: "test-app"},
+					Name:   "other-test-app",
+				},
+			}, metav1.CreateOptions{})
+			if err != nil {
+				t.Fatalf("expected nil got %#v", err)
+			}
+
+			files := map[string]string{}
+			if tc.crd {
+				files["crds/tests.yaml"] = "apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: tests.example.com\n"
+			}
+			_, err = a.k8sClient.CoreV1().Secrets("test").Create(ctx, newReleaseSecret(t, "test-app", files), metav1.CreateOptions{})
+			if err != nil {
+				t.Fatalf("expected nil got %#v", err)
+			}
+
+			_, err = a.k8sClient.AppsV1().Deployments("test").Create(ctx, &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: releaseAnnotations,
+					Name:        "test-app",
+					Namespace:   "test",
+				},
+			}, metav1.CreateOptions{})
+			if err != nil {
+				t.Fatalf("expected nil got %#v", err)
+			}
+
+			if tc.statefulSet {
+				_, err = a.k8sClient.AppsV1().StatefulSets("test").Create(ctx, &appsv1.StatefulSet{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: releaseAnnotations,
+						Name:        "test-app",
+						Namespace:   "test",
+					},
+					Spec: appsv1.StatefulSetSpec{
+						VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+							{ObjectMeta: metav1.ObjectMeta{Name: "data"}},
+						},
+					},
+				}, metav1.CreateOptions{})
+				if err != nil {
+					t.Fatalf("expected nil got %#v", err)
+				}
+
+				_, err = a.k8sClient.CoreV1().PersistentVolumeClaims("test").Create(ctx, &corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "data-test-app-0",
+						Namespace: "test",
+					},
+				}, metav1.CreateOptions{})
+				if err != nil {
+					t.Fatalf("expected nil got %#v", err)
+				}
+			}
+
+			// Helm installs the CRDs of the crds directory without
+			// release annotations.
+			if tc.crd {
+				err = a.ctrlClient.Create(ctx, &apiextensionsv1.CustomResourceDefinition{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "tests.example.com",
+					},
+				})
+				if err != nil {
+					t.Fatalf("expected nil got %#v", err)
+				}
+			}
+
+			err = a.UninstallApp(ctx, App{
+				Name:      "test-app",
+				Namespace: "test",
+			})
+
+			if tc.expectedLeftovers == nil {
+				if err != nil {
+					t.Fatalf("expected nil got %#v", err)
+				}
+				return
+			}
+
+			if !IsResourcesLeft(err) {
+				t.Fatalf("expected resources left error got %#v", err)
+			}
+
+			var leftoverErr *LeftoverError
+			if !errors.As(err, &leftoverErr) {
+				t.Fatalf("expected leftover error got %#v", err)
+			}
+			if !reflect.DeepEqual(leftoverErr.Leftovers, tc.expectedLeftovers) {
+				t.Fatalf("expected leftovers %#v got %#v", tc.expectedLeftovers, leftoverErr.Leftovers)
+			}
+		})
+	}
+}
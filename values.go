@@ -0,0 +1,31 @@
+package apptest
+
+import (
+	"github.com/giantswarm/microerror"
+	"sigs.k8s.io/yaml"
+
+	"github.com/giantswarm/apptest/internal/chartvalues"
+)
+
+// mergeValuesData parses the values YAML stored under the single key of a
+// config map or secret and merges it into values.
+func mergeValuesData(values map[string]interface{}, data map[string][]byte, description string) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if len(data) > 1 {
+		return microerror.Maskf(executionFailedError, "expected %s to have a single key, got %d", description, len(data))
+	}
+
+	for _, v := range data {
+		var parsed map[string]interface{}
+		err := yaml.Unmarshal(v, &parsed)
+		if err != nil {
+			return microerror.Maskf(executionFailedError, "failed to parse values of %s: %s", description, err)
+		}
+
+		chartvalues.Merge(values, parsed)
+	}
+
+	return nil
+}
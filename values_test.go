@@ -0,0 +1,193 @@
+package apptest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_InstallApps_values(t *testing.T) {
+	ctx := context.Background()
+
+	type credentials struct {
+		Password string `json:"password"`
+	}
+
+	a := newTestAppSetup(t, Config{})
+
+	app := App{
+		CatalogConfig: v1alpha1.CatalogSpecConfig{
+			ConfigMap: &v1alpha1.CatalogSpecConfigConfigMap{
+				Name:      "default-catalog-values",
+				Namespace: defaultNamespace,
+			},
+		},
+		CatalogName: "default",
+		Config: v1alpha1.AppSpecConfig{
+			ConfigMap: v1alpha1.AppSpecConfigConfigMap{
+				Name:      "cluster-values",
+				Namespace: defaultNamespace,
+			},
+			Secret: v1alpha1.AppSpecConfigSecret{
+				Name:      "cluster-secrets",
+				Namespace: defaultNamespace,
+			},
+		},
+		Name:      "test-app",
+		Namespace: "test",
+		SecretValues: map[string]interface{}{
+			"credentials": credentials{Password: "secret"},
+		},
+		Values: map[string]interface{}{
+			"replicas": 2,
+		},
+		Version: "1.0.0",
+	}
+
+	installed, err := a.InstallAppsWithResult(ctx, []App{app})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	if installed[0].UserConfigMapName != "test-app-user-values" {
+		t.Fatalf("expected user config map %#q got %#q", "test-app-user-values", installed[0].UserConfigMapName)
+	}
+	if installed[0].UserSecretName != "test-app-user-secrets" {
+		t.Fatalf("expected user secret %#q got %#q", "test-app-user-secrets", installed[0].UserSecretName)
+	}
+	if installed[0].CatalogURL != "https://giantswarm.github.io/default-catalog/" {
+		t.Fatalf("expected catalog URL %#q got %#q", "https://giantswarm.github.io/default-catalog/", installed[0].CatalogURL)
+	}
+
+	configMap, err := a.k8sClient.CoreV1().ConfigMaps(defaultNamespace).Get(ctx, "test-app-user-values", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if configMap.Data["values"] != "replicas: 2\n" {
+		t.Fatalf("expected values %#q got %#q", "replicas: 2\n", configMap.Data["values"])
+	}
+
+	secret, err := a.k8sClient.CoreV1().Secrets(defaultNamespace).Get(ctx, "test-app-user-secrets", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	expectedSecrets := "credentials:\n  password: secret\n"
+	if string(secret.Data["secrets"]) != expectedSecrets {
+		t.Fatalf("expected secrets %#q got %#q", expectedSecrets, string(secret.Data["secrets"]))
+	}
+
+	var appCR v1alpha1.App
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "test-app", Namespace: defaultNamespace}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if appCR.Spec.UserConfig.ConfigMap.Name != "test-app-user-values" {
+		t.Fatalf("expected user config map %#q got %#q", "test-app-user-values", appCR.Spec.UserConfig.ConfigMap.Name)
+	}
+	if appCR.Spec.UserConfig.Secret.Name != "test-app-user-secrets" {
+		t.Fatalf("expected user secret %#q got %#q", "test-app-user-secrets", appCR.Spec.UserConfig.Secret.Name)
+	}
+	if appCR.Spec.Config != app.Config {
+		t.Fatalf("expected config %#v got %#v", app.Config, appCR.Spec.Config)
+	}
+
+	var catalog v1alpha1.Catalog
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "default", Namespace: metav1.NamespaceDefault}, &catalog)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if catalog.Spec.Config == nil || catalog.Spec.Config.ConfigMap.Name != "default-catalog-values" {
+		t.Fatalf("expected catalog config map %#q got %#v", "default-catalog-values", catalog.Spec.Config)
+	}
+}
+
+func Test_marshalValues(t *testing.T) {
+	_, err := marshalValues("replicas: 1", map[string]interface{}{"replicas": 2}, "Values")
+	if !IsInvalidConfig(err) {
+		t.Fatalf("expected invalid config error got %#v", err)
+	}
+
+	valuesYAML, err := marshalValues("replicas: 1", nil, "Values")
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if valuesYAML != "replicas: 1" {
+		t.Fatalf("expected %#q got %#q", "replicas: 1", valuesYAML)
+	}
+}
+
+func Test_MergedValues(t *testing.T) {
+	ctx := context.Background()
+
+	chart := &v1alpha1.Chart{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app",
+			Namespace: defaultNamespace,
+		},
+		Spec: v1alpha1.ChartSpec{
+			Config: v1alpha1.ChartSpecConfig{
+				ConfigMap: v1alpha1.ChartSpecConfigConfigMap{
+					Name:      "test-app-chart-values",
+					Namespace: defaultNamespace,
+				},
+				Secret: v1alpha1.ChartSpecConfigSecret{
+					Name:      "test-app-chart-secrets",
+					Namespace: defaultNamespace,
+				},
+			},
+		},
+	}
+
+	a := newTestAppSetup(t, Config{}, chart)
+
+	_, err := a.k8sClient.CoreV1().ConfigMaps(defaultNamespace).Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app-chart-values",
+			Namespace: defaultNamespace,
+		},
+		Data: map[string]string{
+			"values": "credentials:\n  user: admin\n  password: default\nreplicas: 2\n",
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	_, err = a.k8sClient.CoreV1().Secrets(defaultNamespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app-chart-secrets",
+			Namespace: defaultNamespace,
+		},
+		Data: map[string][]byte{
+			"values": []byte("credentials:\n  password: secret\n"),
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	values, err := a.MergedValues(ctx, App{Name: "test-app"})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	expected := map[string]interface{}{
+		"credentials": map[string]interface{}{
+			"user":     "admin",
+			"password": "secret",
+		},
+		"replicas": float64(2),
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected values %#v got %#v", expected, values)
+	}
+
+	_, err = a.MergedValues(ctx, App{Name: "other-app"})
+	if !IsNotFound(err) {
+		t.Fatalf("expected not found error got %#v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package apptest
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/giantswarm/microerror"
+)
+
+// parseVersionConstraint parses the version as a semver constraint such as
+// ^2.3, ~1.5.0 or >=3.0.0 <4.0.0. Exact versions, also with a leading v, are
+// no constraints.
+func parseVersionConstraint(version string) (*semver.Constraints, bool) {
+	_, err := semver.StrictNewVersion(strings.TrimPrefix(version, "v"))
+	if err == nil {
+		return nil, false
+	}
+
+	constraint, err := semver.NewConstraint(version)
+	if err != nil {
+		return nil, false
+	}
+
+	return constraint, true
+}
+
+// resolveVersionConstraint returns the highest version of the app in its
+// catalog which satisfies the constraint.
+func (a *AppSetup) resolveVersionConstraint(ctx context.Context, app App, constraint *semver.Constraints) (string, error) {
+	catalogURL, err := a.getCatalogURL(app)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	if catalogType(app, catalogURL) == CatalogTypeOCI {
+		tags, err := a.listOCIVersions(ctx, app, catalogURL)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+
+		version, err := highestVersion(app, tags, constraint)
+		if err != nil {
+			return "", microerror.Mask(err)
+		}
+
+		return version, nil
+	}
+
+	version, err := a.findInCatalogIndex(ctx, app, catalogURL, func(index catalogIndex) (string, error) {
+		var versions []string
+		for _, e := range index.Entries[app.Name] {
+			versions = append(versions, e.Version)
+		}
+
+		return highestVersion(app, versions, constraint)
+	})
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return version, nil
+}
+
+// highestVersion returns the highest of the versions of the app which
+// satisfies the constraint.
+func highestVersion(app App, versions []string, constraint *semver.Constraints) (string, error) {
+	var latest *semver.Version
+	var latestVersion string
+	for _, v := range versions {
+		parsed, err := semver.NewVersion(v)
+		if err != nil {
+			continue
+		}
+
+		if !constraint.Check(parsed) {
+			continue
+		}
+
+		if latest == nil || parsed.GreaterThan(latest) {
+			latest = parsed
+			latestVersion = v
+		}
+	}
+
+	if latest == nil {
+		return "", microerror.Mask(newAppError(versionNotFoundError, app.Name, "", "", "no version of app %#q in catalog %#q satisfies %#q", app.Name, app.CatalogName, app.Version))
+	}
+
+	return latestVersion, nil
+}
+
+// listOCIVersions returns every version of the app in its OCI catalog.
+func (a *AppSetup) listOCIVersions(ctx context.Context, app App, catalogURL string) ([]string, error) {
+	if a.offline {
+		return nil, microerror.Mask(newAppError(catalogUnreachableError, app.Name, "", "", "versions of app %#q in OCI catalog %#q can't be resolved in offline mode", app.Name, catalogURL))
+	}
+
+	tags, err := a.listOCITags(ctx, catalogURL, app.Name)
+	if IsNotFound(err) {
+		return nil, microerror.Mask(newAppError(versionNotFoundError, app.Name, "", "", "app %#q in OCI catalog %#q: %s", app.Name, catalogURL, err))
+	} else if IsInvalidConfig(err) {
+		return nil, microerror.Mask(err)
+	} else if err != nil {
+		return nil, microerror.Mask(newAppError(catalogUnreachableError, app.Name, "", "", "failed to list tags of app %#q in OCI catalog %#q: %s", app.Name, catalogURL, err))
+	}
+
+	return tags, nil
+}
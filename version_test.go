@@ -0,0 +1,153 @@
+package apptest
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	testConstraintIndexYAML = `apiVersion: v1
+entries:
+  cert-manager-app:
+  - name: cert-manager-app
+    version: 1.5.0
+  - name: cert-manager-app
+    version: 1.5.3
+  - name: cert-manager-app
+    version: 1.6.0
+  - name: cert-manager-app
+    version: 2.3.0
+  - name: cert-manager-app
+    version: 2.4.1
+  - name: cert-manager-app
+    version: 3.0.0-ad12c88111d7513114a1257994634e2ae81115a2
+  - name: cert-manager-app
+    version: 3.1.0
+`
+)
+
+func Test_getVersionForApp_constraint(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "index.yaml")
+	err := ioutil.WriteFile(indexPath, []byte(testConstraintIndexYAML), 0644) // #nosec
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	testCases := []struct {
+		name            string
+		version         string
+		expectedVersion string
+		errorMatcher    func(error) bool
+	}{
+		{
+			name:            "case 0: exact version is not resolved",
+			version:         "9.9.9",
+			expectedVersion: "9.9.9",
+		},
+		{
+			name:            "case 1: caret constraint",
+			version:         "^2.3",
+			expectedVersion: "2.4.1",
+		},
+		{
+			name:            "case 2: tilde constraint",
+			version:         "~1.5.0",
+			expectedVersion: "1.5.3",
+		},
+		{
+			name:            "case 3: range ignores pre-releases",
+			version:         ">=3.0.0 <4.0.0",
+			expectedVersion: "3.1.0",
+		},
+		{
+			name:         "case 4: no matching version",
+			version:      "^4",
+			errorMatcher: IsVersionNotFound,
+		},
+		{
+			name:            "case 5: exact version with leading v is not resolved",
+			version:         "v9.9.9",
+			expectedVersion: "v9.9.9",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestAppSetup(t, Config{
+				CatalogIndexFiles: map[string]string{
+					"default": indexPath,
+				},
+				Offline: true,
+			})
+
+			app := App{
+				CatalogName: "default",
+				Name:        "cert-manager-app",
+				Version:     tc.version,
+			}
+
+			version, err := a.getVersionForApp(context.Background(), app)
+
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+
+			if version != tc.expectedVersion {
+				t.Fatalf("version == %#q, want %#q", version, tc.expectedVersion)
+			}
+		})
+	}
+}
+
+func Test_InstallAppsWithResult_constraint(t *testing.T) {
+	ctx := context.Background()
+
+	indexPath := filepath.Join(t.TempDir(), "index.yaml")
+	err := ioutil.WriteFile(indexPath, []byte(testConstraintIndexYAML), 0644) // #nosec
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	a := newTestAppSetup(t, Config{
+		CatalogIndexFiles: map[string]string{
+			"default": indexPath,
+		},
+	})
+
+	installed, err := a.InstallAppsWithResult(ctx, []App{
+		{
+			CatalogName: "default",
+			Name:        "cert-manager-app",
+			Namespace:   "kube-system",
+			Version:     "^2.3",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	if len(installed) != 1 || installed[0].Version != "2.4.1" {
+		t.Fatalf("expected resolved version %#q got %#v", "2.4.1", installed)
+	}
+
+	var appCR v1alpha1.App
+	err = a.ctrlClient.Get(ctx, types.NamespacedName{Name: "cert-manager-app", Namespace: defaultNamespace}, &appCR)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	if appCR.Spec.Version != "2.4.1" {
+		t.Fatalf("expected version %#q got %#q", "2.4.1", appCR.Spec.Version)
+	}
+}
@@ -0,0 +1,257 @@
+package apptest
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	cenkaltibackoff "github.com/cenkalti/backoff"
+	"github.com/giantswarm/backoff"
+	"github.com/giantswarm/microerror"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	defaultCRDWaitInterval = 10 * time.Second
+	defaultCRDWaitTimeout  = 1 * time.Minute
+	defaultWaitInterval    = 10 * time.Second
+	defaultWaitTimeout     = 20 * time.Minute
+)
+
+// waiter describes how to wait for an object to reach a desired state.
+type waiter struct {
+	description string
+	name        string
+	namespace   string
+	// objType is the typed object passed to check.
+	objType runtime.Object
+
+	// check returns nil once the object reached the desired state. Errors
+	// wrapped with backoff.Permanent stop the wait.
+	check func(obj runtime.Object) error
+	// get fetches the object when polling.
+	get func(ctx context.Context) (runtime.Object, error)
+	// resource of the object. It is watched as unstructured using the
+	// dynamic client. Without dynamic client the object is polled using
+	// get.
+	resource schema.GroupVersionResource
+
+	// backOff is used when polling.
+	backOff backoff.BackOff
+	// interval is the resync period of the informer.
+	interval time.Duration
+	// timeout is how long to wait when watching.
+	timeout time.Duration
+}
+
+// waitFor waits until the object described by the waiter reaches the desired
+// state. Changes are picked up immediately by an informer which also resyncs
+// the object every interval. Without dynamic client the object is polled.
+// Waiting stops when the context is cancelled or its deadline is exceeded
+// and as soon as listing or watching the object is forbidden or
+// unauthorized. Other list and watch errors are retried by the informer and
+// the last one is returned once waiting stops.
+func (a *AppSetup) waitFor(ctx context.Context, w waiter) error {
+	if a.dynamicClient == nil {
+		o := func() error {
+			obj, err := w.get(ctx)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+
+			return w.check(obj)
+		}
+
+		n := func(err error, t time.Duration) {
+			a.logger.Errorf(ctx, err, "failed to get %s: retrying in %s", w.description, t)
+		}
+
+		err := retryNotify(ctx, o, w.backOff, n)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var lastErr, watchErr error
+	result := make(chan error, 1)
+
+	handle := func(obj interface{}) {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok || u.GetName() != w.name || u.GetNamespace() != w.namespace {
+			return
+		}
+
+		o := w.objType.DeepCopyObject()
+
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, o)
+		if err != nil {
+			a.logger.Errorf(ctx, err, "failed to convert %s", w.description)
+			return
+		}
+
+		err = w.check(o)
+		if err == nil {
+			select {
+			case result <- nil:
+			default:
+			}
+
+			return
+		}
+
+		if permanent, ok := err.(*cenkaltibackoff.PermanentError); ok {
+			select {
+			case result <- permanent.Err:
+			default:
+			}
+
+			return
+		}
+
+		a.logger.Debugf(ctx, "waiting for %s: %s", w.description, err)
+
+		mutex.Lock()
+		lastErr = err
+		mutex.Unlock()
+	}
+
+	// Requests which are forbidden or unauthorized won't succeed when
+	// retried.
+	failed := func(err error) {
+		if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
+			select {
+			case result <- err:
+			default:
+			}
+		}
+	}
+
+	informer := cache.NewSharedIndexInformer(newListerWatcher(ctx, a.dynamicClient, w.resource, w.namespace, w.name, failed), &unstructured.Unstructured{}, w.interval, cache.Indexers{})
+
+	// Watches closed by the API server are restarted silently like by the
+	// default handler. Other errors are retried by the informer.
+	err := informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		if err == io.EOF || apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+			return
+		}
+
+		a.logger.Errorf(ctx, err, "failed to list or watch %s", w.description)
+
+		mutex.Lock()
+		watchErr = err
+		mutex.Unlock()
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: handle,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			handle(newObj)
+		},
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go informer.Run(stop)
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		return nil
+	case <-ctx.Done():
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !informer.HasSynced() {
+			return microerror.Maskf(executionFailedError, "%s waiting for %s, failed to list it, last error: %v", ctx.Err(), w.description, watchErr)
+		} else if watchErr != nil {
+			return microerror.Maskf(executionFailedError, "%s waiting for %s, last error: %v, last watch error: %v", ctx.Err(), w.description, lastErr, watchErr)
+		}
+
+		return microerror.Maskf(executionFailedError, "%s waiting for %s, last error: %v", ctx.Err(), w.description, lastErr)
+	}
+}
+
+// retryNotify retries the operation like backoff.RetryNotify but stops
+// waiting as soon as the context is cancelled or its deadline is exceeded.
+func retryNotify(ctx context.Context, o backoff.Operation, b backoff.BackOff, n backoff.Notify) error {
+	err := backoff.RetryNotify(o, cenkaltibackoff.WithContext(b, ctx), n)
+	if err != nil && ctx.Err() != nil {
+		return microerror.Maskf(executionFailedError, "%s, last error: %s", ctx.Err(), err)
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// newListerWatcher returns a lister watcher for a single object of the given
+// resource. Its requests are cancelled with the context. Their errors are
+// passed to failed.
+func newListerWatcher(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string, failed func(error)) cache.ListerWatcher {
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", name).String()
+
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			list, err := dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, options)
+			if err != nil {
+				failed(err)
+				return nil, err
+			}
+
+			return list, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			w, err := dynamicClient.Resource(gvr).Namespace(namespace).Watch(ctx, options)
+			if err != nil {
+				failed(err)
+				return nil, err
+			}
+
+			return w, nil
+		},
+	}
+
+	return lw
+}
+
+// waitInterval returns how often the app CR status is checked.
+func (a *AppSetup) waitInterval(app App) time.Duration {
+	if app.WaitInterval != 0 {
+		return app.WaitInterval
+	}
+
+	return a.defaultWaitInterval
+}
+
+// waitTimeout returns how long to wait for the app to be deployed.
+func (a *AppSetup) waitTimeout(app App) time.Duration {
+	if app.WaitTimeout != 0 {
+		return app.WaitTimeout
+	}
+
+	return a.defaultWaitTimeout
+}
@@ -0,0 +1,370 @@
+package apptest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/giantswarm/apiextensions/v3/pkg/apis/application/v1alpha1"
+	"github.com/giantswarm/micrologger/microloggertest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clienttesting "k8s.io/client-go/testing"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/apptest/internal/applyfake"
+)
+
+// appliedByApptest returns the managed fields of app CRs apptest applied
+// before, e.g. in a previous run. app-operator only sets their status.
+func appliedByApptest() []metav1.ManagedFieldsEntry {
+	return []metav1.ManagedFieldsEntry{
+		{
+			Manager:   fieldManager,
+			Operation: metav1.ManagedFieldsOperationApply,
+		},
+	}
+}
+
+func newTestAppSetup(t *testing.T, config Config, objs ...runtime.Object) *AppSetup {
+	t.Helper()
+
+	s := runtime.NewScheme()
+	err := clientgoscheme.AddToScheme(s)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+	err = v1alpha1.AddToScheme(s)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	k8sClient := k8sfake.NewSimpleClientset()
+	applyfake.AddReactor(k8sClient, fieldManager)
+
+	config.CtrlClient = applyfake.NewClient(ctrlfake.NewFakeClientWithScheme(s, objs...), s)
+	config.K8sClient = k8sClient
+	config.Logger = microloggertest.New()
+	config.Scheme = s
+
+	a, err := New(config)
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	return a
+}
+
+func Test_waitForDeployedApp(t *testing.T) {
+	pendingApp := &v1alpha1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-app",
+			Namespace: defaultNamespace,
+		},
+		Status: v1alpha1.AppStatus{
+			Release: v1alpha1.AppStatusRelease{
+				Status: "pending-install",
+			},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		config      Config
+		app         App
+		ctxTimeout  time.Duration
+		maxDuration time.Duration
+	}{
+		{
+			name: "case 0: app timeout is honoured",
+			config: Config{
+				WaitTimeout: time.Hour,
+			},
+			app: App{
+				Name:         "test-app",
+				Version:      "1.0.0",
+				WaitInterval: 10 * time.Millisecond,
+				WaitTimeout:  100 * time.Millisecond,
+			},
+			ctxTimeout:  time.Hour,
+			maxDuration: 5 * time.Second,
+		},
+		{
+			name: "case 1: config timeout is honoured",
+			config: Config{
+				WaitInterval: 10 * time.Millisecond,
+				WaitTimeout:  100 * time.Millisecond,
+			},
+			app: App{
+				Name:    "test-app",
+				Version: "1.0.0",
+			},
+			ctxTimeout:  time.Hour,
+			maxDuration: 5 * time.Second,
+		},
+		{
+			name: "case 2: context deadline is honoured",
+			app: App{
+				Name:    "test-app",
+				Version: "1.0.0",
+			},
+			ctxTimeout:  100 * time.Millisecond,
+			maxDuration: 5 * time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tc.ctxTimeout)
+			defer cancel()
+
+			a := newTestAppSetup(t, tc.config, pendingApp.DeepCopy())
+
+			start := time.Now()
+
+			err := a.waitForDeployedApp(ctx, tc.app)
+			if !IsWaitTimeout(err) {
+				t.Fatalf("expected wait timeout error got %#v", err)
+			}
+
+			var appErr *AppError
+			if !errors.As(err, &appErr) || appErr.Name != "test-app" || appErr.Status != "pending-install" {
+				t.Fatalf("expected app error for %#q with status %#q got %#v", "test-app", "pending-install", appErr)
+			}
+
+			if time.Since(start) > tc.maxDuration {
+				t.Fatalf("expected wait to stop within %s got %s", tc.maxDuration, time.Since(start))
+			}
+		})
+	}
+}
+
+func Test_waitForDeployedApp_watch(t *testing.T) {
+	testCases := []struct {
+		name         string
+		status       string
+		errorMatcher func(error) bool
+	}{
+		{
+			name:   "case 0: deployed app is picked up before the resync",
+			status: deployedStatus,
+		},
+		{
+			name:         "case 1: failed app stops the wait",
+			status:       failedStatus,
+			errorMatcher: IsAppFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			gvr := v1alpha1.SchemeGroupVersion.WithResource("apps")
+			app := &unstructured.Unstructured{}
+			app.SetAPIVersion(v1alpha1.SchemeGroupVersion.String())
+			app.SetKind("App")
+			app.SetName("test-app")
+			app.SetNamespace(defaultNamespace)
+
+			dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{gvr: "AppList"}, app)
+
+			a := newTestAppSetup(t, Config{})
+			a.dynamicClient = dynamicClient
+
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+
+				updated := app.DeepCopy()
+				_ = unstructured.SetNestedField(updated.Object, tc.status, "status", "release", "status")
+				_ = unstructured.SetNestedField(updated.Object, "1.0.0", "status", "version")
+
+				_, err := dynamicClient.Resource(gvr).Namespace(defaultNamespace).Update(ctx, updated, metav1.UpdateOptions{})
+				if err != nil {
+					t.Errorf("expected nil got %#v", err)
+				}
+			}()
+
+			testApp := App{
+				Name:         "test-app",
+				Version:      "1.0.0",
+				WaitInterval: time.Hour,
+				WaitTimeout:  time.Hour,
+			}
+
+			err := a.waitForDeployedApp(ctx, testApp)
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+
+			if ctx.Err() != nil {
+				t.Fatalf("expected status change to be picked up before the context deadline")
+			}
+		})
+	}
+}
+
+func Test_waitForDeployedApp_watchError(t *testing.T) {
+	testCases := []struct {
+		name          string
+		err           error
+		waitTimeout   time.Duration
+		expectedError string
+	}{
+		{
+			name:          "case 0: forbidden list stops the wait",
+			err:           apierrors.NewForbidden(schema.GroupResource{Group: v1alpha1.SchemeGroupVersion.Group, Resource: "apps"}, "", errors.New("no rbac")),
+			waitTimeout:   time.Hour,
+			expectedError: "no rbac",
+		},
+		{
+			name:          "case 1: failing list is returned once the wait times out",
+			err:           apierrors.NewInternalError(errors.New("etcd unavailable")),
+			waitTimeout:   500 * time.Millisecond,
+			expectedError: "etcd unavailable",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			gvr := v1alpha1.SchemeGroupVersion.WithResource("apps")
+			dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{gvr: "AppList"})
+			dynamicClient.PrependReactor("list", "apps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, tc.err
+			})
+
+			a := newTestAppSetup(t, Config{})
+			a.dynamicClient = dynamicClient
+
+			testApp := App{
+				Name:         "test-app",
+				Version:      "1.0.0",
+				WaitInterval: time.Hour,
+				WaitTimeout:  tc.waitTimeout,
+			}
+
+			err := a.waitForDeployedApp(ctx, testApp)
+			if !IsWaitTimeout(err) {
+				t.Fatalf("expected wait timeout error got %#v", err)
+			}
+			if !strings.Contains(err.Error(), tc.expectedError) {
+				t.Fatalf("expected error to contain %#q got %#q", tc.expectedError, err.Error())
+			}
+
+			if ctx.Err() != nil {
+				t.Fatalf("expected wait to stop before the context deadline")
+			}
+		})
+	}
+}
+
+func Test_InstallAppsWithResult_release(t *testing.T) {
+	ctx := context.Background()
+
+	deployedApp := &v1alpha1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			ManagedFields: appliedByApptest(),
+			Name:          "test-app",
+			Namespace:     "org-test",
+		},
+		Status: v1alpha1.AppStatus{
+			Release: v1alpha1.AppStatusRelease{
+				Status: deployedStatus,
+			},
+			Version: "1.0.0",
+		},
+	}
+
+	a := newTestAppSetup(t, Config{WaitInterval: time.Millisecond}, deployedApp)
+
+	installed, err := a.InstallAppsWithResult(ctx, []App{
+		{
+			AppCRNamespace: "org-test",
+			CatalogName:    "default",
+			Name:           "test-app",
+			Namespace:      "test",
+			Version:        "1.0.0",
+			WaitForDeploy:  true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected nil got %#v", err)
+	}
+
+	if installed[0].AppCR.Name != "test-app" || installed[0].AppCR.Namespace != "org-test" {
+		t.Fatalf("expected app CR %#q got %#q", "org-test/test-app", installed[0].AppCR.String())
+	}
+	if installed[0].Release.Status != deployedStatus {
+		t.Fatalf("expected release status %#q got %#q", deployedStatus, installed[0].Release.Status)
+	}
+	if installed[0].Duration <= 0 {
+		t.Fatalf("expected positive duration got %s", installed[0].Duration)
+	}
+}
+
+func Test_InstallApps_appFailed(t *testing.T) {
+	failedApp := &v1alpha1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			ManagedFields: appliedByApptest(),
+			Name:          "failed-app",
+			Namespace:     defaultNamespace,
+		},
+		Status: v1alpha1.AppStatus{
+			Release: v1alpha1.AppStatusRelease{
+				Reason: "chart not found",
+				Status: failedStatus,
+			},
+		},
+	}
+
+	a := newTestAppSetup(t, Config{WaitInterval: time.Millisecond}, failedApp)
+
+	err := a.InstallApps(context.Background(), []App{
+		{
+			CatalogName:   "default",
+			Name:          "failed-app",
+			Namespace:     "test",
+			Version:       "1.0.0",
+			WaitForDeploy: true,
+		},
+		{
+			CatalogName: "default",
+			Name:        "other-app",
+			Namespace:   "test",
+			Version:     "1.0.0",
+		},
+	})
+	if !IsAppFailed(err) {
+		t.Fatalf("expected app failed error got %#v", err)
+	}
+	if IsWaitTimeout(err) {
+		t.Fatalf("expected no wait timeout error got %#v", err)
+	}
+
+	var appErr *AppError
+	if !errors.As(err, &appErr) {
+		t.Fatalf("expected app error got %#v", err)
+	}
+	if appErr.Name != "failed-app" || appErr.Status != failedStatus || appErr.Reason != "chart not found" {
+		t.Fatalf("expected app error for %#q with status %#q and reason %#q got %#v", "failed-app", failedStatus, "chart not found", appErr)
+	}
+}